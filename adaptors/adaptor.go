@@ -16,7 +16,15 @@
 
 package adaptors
 
+import "context"
+
 type Adaptor interface {
 	New() (Adaptor, error)
 	Decode(jwt string, jwkUri string) (interface{}, error)
 }
+
+// ContextAdaptor is an Adaptor whose key set lookups honour a context.
+type ContextAdaptor interface {
+	Adaptor
+	DecodeContext(ctx context.Context, jwt string, jwkUri string) (interface{}, error)
+}
@@ -0,0 +1,90 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package lestrratGoJwx
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/utils"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+)
+
+// ParseKeySet parses a JWKS document or a bundle of PEM encoded public keys,
+// private keys and x509 certificates into a key set. Only the public part of
+// private keys is kept. A PEM block may name its key with a "kid" header;
+// keys without one are tried in turn against tokens whose kid is not found.
+func ParseKeySet(data []byte) (jwk.Set, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("{")) {
+		set, err := jwk.Parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse JWKS: %w", err)
+		}
+		return set, nil
+	}
+
+	set := jwk.NewSet()
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		key, err := jwk.ParseKey(pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: block.Bytes}), jwk.WithPEM(true))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse PEM block %q: %w", block.Type, err)
+		}
+		key, err = jwk.PublicKeyOf(key)
+		if err != nil {
+			return nil, fmt.Errorf("could not get public key of PEM block %q: %w", block.Type, err)
+		}
+		if kid := block.Headers["kid"]; kid != "" {
+			if err := key.Set(jwk.KeyIDKey, kid); err != nil {
+				return nil, err
+			}
+		}
+		if err := set.AddKey(key); err != nil {
+			return nil, err
+		}
+	}
+	if len(bytes.TrimSpace(data)) > 0 || set.Len() == 0 {
+		return nil, fmt.Errorf("key set is neither a JWKS document nor PEM encoded")
+	}
+	return set, nil
+}
+
+// KeySetCodec encodes key sets as JWKS documents, so they can be kept in a
+// cache built with utils.NewSharedContextCache.
+type KeySetCodec struct{}
+
+func (KeySetCodec) Marshal(value interface{}) ([]byte, error) {
+	set, ok := value.(jwk.Set)
+	if !ok {
+		return nil, fmt.Errorf("could not cast %v to jwk.Set", value)
+	}
+	return json.Marshal(set)
+}
+
+func (KeySetCodec) Unmarshal(data []byte) (interface{}, error) {
+	return jwk.Parse(data)
+}
+
+// KeySetCodec implements the utils.Codec interface
+var _ utils.Codec = KeySetCodec{}
@@ -20,34 +20,101 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hung12ct/okta-jwt-verifier-golang/v2/adaptors"
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
 	"github.com/hung12ct/okta-jwt-verifier-golang/v2/utils"
+	"github.com/lestrrat-go/jwx/v2/jwa"
 	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/patrickmn/go-cache"
 )
 
 func (lgj *LestrratGoJwx) fetchJwkSet(jwkUri string) (interface{}, error) {
-	return jwk.Fetch(context.Background(), jwkUri, jwk.WithHTTPClient(lgj.Client))
+	return lgj.fetchJwkSetContext(context.Background(), jwkUri)
+}
+
+func (lgj *LestrratGoJwx) fetchJwkSetContext(ctx context.Context, jwkUri string) (interface{}, error) {
+	return jwk.Fetch(ctx, jwkUri, jwk.WithHTTPClient(lgj.Client))
 }
 
 type LestrratGoJwx struct {
-	JWKSet      jwk.Set
-	Cache       func(func(string) (interface{}, error), time.Duration, time.Duration) (utils.Cacher, error)
-	jwkSetCache utils.Cacher
-	Timeout     time.Duration
-	Cleanup     time.Duration
-	Client      *http.Client
+	// JWKSet, when set, is the only key set tokens are verified with. The
+	// jwkUri given to Decode is then ignored and nothing is ever fetched.
+	// Use SetJWKSet to replace it once the adaptor is in use.
+	JWKSet jwk.Set
+	Cache  func(func(string) (interface{}, error), time.Duration, time.Duration) (utils.Cacher, error)
+	// ContextCache is used instead of Cache when set, and lets key set
+	// fetches be cancelled through the context given to DecodeContext.
+	ContextCache func(utils.ContextLookup, time.Duration, time.Duration) (utils.ContextCacher, error)
+	jwkSetCache  utils.Cacher
+	Timeout      time.Duration
+	Cleanup      time.Duration
+	Client       *http.Client
+
+	// SigningAlgorithms is the allow-list of JWS algorithms accepted by
+	// Decode. It defaults to RS256 only.
+	SigningAlgorithms []string
+
+	// MinRefreshInterval is the minimum time between two forced refreshes of
+	// a key set, triggered by a token whose kid is not in the cached set. It
+	// defaults to 30 seconds.
+	MinRefreshInterval time.Duration
+	// UnknownKidTimeout is how long a kid that was still missing after a
+	// forced refresh is rejected without asking the server again. It
+	// defaults to Timeout.
+	UnknownKidTimeout time.Duration
+
+	// refreshMutex guards lastRefresh and refreshing. It is never held
+	// while a key set is fetched.
+	refreshMutex sync.Mutex
+	lastRefresh  map[string]time.Time
+	refreshing   map[string]*keySetRefresh
+	unknownKids  *cache.Cache
+	staticSet    atomic.Value
+	// refreshed holds the key sets fetched by forced refreshes when the
+	// key set cache cannot be refreshed, until the cache catches up.
+	refreshed *cache.Cache
 }
 
 func (lgj *LestrratGoJwx) New() (adaptors.Adaptor, error) {
 	var err error
-	if lgj.Cache == nil {
-		lgj.Cache = utils.NewDefaultCache
+	if lgj.Cache == nil && lgj.ContextCache == nil {
+		lgj.ContextCache = utils.NewDefaultContextCache
+	}
+	if len(lgj.SigningAlgorithms) == 0 {
+		lgj.SigningAlgorithms = []string{jwa.RS256.String()}
+	}
+	if lgj.MinRefreshInterval == 0 {
+		lgj.MinRefreshInterval = 30 * time.Second
+	}
+	if lgj.UnknownKidTimeout == 0 {
+		lgj.UnknownKidTimeout = lgj.Timeout
+	}
+	if lgj.UnknownKidTimeout == 0 {
+		lgj.UnknownKidTimeout = 5 * time.Minute
+	}
+	if lgj.JWKSet != nil {
+		lgj.staticSet.Store(lgj.JWKSet)
+	}
+	lgj.lastRefresh = map[string]time.Time{}
+	lgj.refreshing = map[string]*keySetRefresh{}
+	lgj.unknownKids = cache.New(lgj.UnknownKidTimeout, 2*lgj.UnknownKidTimeout)
+	refreshedTimeout := lgj.Timeout
+	if refreshedTimeout == 0 {
+		refreshedTimeout = 5 * time.Minute
+	}
+	lgj.refreshed = cache.New(refreshedTimeout, 2*refreshedTimeout)
+	if lgj.ContextCache != nil {
+		lgj.jwkSetCache, err = lgj.ContextCache(lgj.fetchJwkSetContext, lgj.Timeout, lgj.Cleanup)
+	} else {
+		lgj.jwkSetCache, err = lgj.Cache(lgj.fetchJwkSet, lgj.Timeout, lgj.Cleanup)
 	}
-	lgj.jwkSetCache, err = lgj.Cache(lgj.fetchJwkSet, lgj.Timeout, lgj.Cleanup)
 	if err != nil {
 		return nil, err
 	}
@@ -55,19 +122,80 @@ func (lgj *LestrratGoJwx) New() (adaptors.Adaptor, error) {
 }
 
 func (lgj *LestrratGoJwx) Decode(jwt string, jwkUri string) (interface{}, error) {
-	value, err := lgj.jwkSetCache.Get(jwkUri)
+	return lgj.DecodeContext(context.Background(), jwt, jwkUri)
+}
+
+// SetJWKSet replaces the static key set. It is safe to call while tokens
+// are being decoded, and is how key sets read from disk are rotated.
+func (lgj *LestrratGoJwx) SetJWKSet(set jwk.Set) {
+	lgj.staticSet.Store(set)
+}
+
+func (lgj *LestrratGoJwx) DecodeContext(ctx context.Context, jwt string, jwkUri string) (interface{}, error) {
+	jwkSet, static := lgj.staticSet.Load().(jwk.Set)
+	if !static {
+		value, err := utils.GetContext(ctx, lgj.jwkSetCache, jwkUri)
+		if err != nil {
+			return nil, errors.Mark(err, errors.ErrKeySetUnavailable)
+		}
+
+		var ok bool
+		jwkSet, ok = value.(jwk.Set)
+		if !ok {
+			return nil, errors.Mark(fmt.Errorf("could not cast %v to jwk.Set", value), errors.ErrKeySetUnavailable)
+		}
+	}
+
+	msg, err := jws.Parse([]byte(jwt))
 	if err != nil {
-		return nil, err
+		return nil, errors.Mark(err, errors.ErrMalformedToken)
 	}
+	if len(msg.Signatures()) != 1 {
+		return nil, errors.Mark(fmt.Errorf("expected exactly one signature, got %d", len(msg.Signatures())), errors.ErrMalformedToken)
+	}
+	headers := msg.Signatures()[0].ProtectedHeaders()
 
-	jwkSet, ok := value.(jwk.Set)
-	if !ok {
-		return nil, fmt.Errorf("could not cast %v to jwk.Set", value)
+	alg := headers.Algorithm()
+	if !lgj.allowsAlgorithm(alg) {
+		return nil, errors.Mark(fmt.Errorf("alg %q is not allowed", alg), errors.ErrUnsupportedAlg)
 	}
 
-	token, err := jws.Verify([]byte(jwt), jws.WithKeySet(jwkSet))
-	if err != nil {
-		return nil, err
+	var keys []jwk.Key
+	if key, ok := jwkSet.LookupKeyID(headers.KeyID()); ok {
+		keys = []jwk.Key{key}
+	} else if static {
+		// keys read from PEM files often have no kid; try those instead
+		keys = keysWithoutId(jwkSet)
+		if len(keys) == 0 {
+			return nil, errors.Mark(fmt.Errorf("no key with kid %q found in the key set", headers.KeyID()), errors.ErrKeyNotFound)
+		}
+	} else if key, ok := lgj.refreshedKey(jwkUri, headers.KeyID()); ok {
+		keys = []jwk.Key{key}
+	} else {
+		key, err := lgj.refreshForKid(ctx, jwkUri, headers.KeyID())
+		if err != nil {
+			return nil, err
+		}
+		keys = []jwk.Key{key}
+	}
+
+	var token []byte
+	var matchErr, verifyErr error
+	for _, key := range keys {
+		if err := keyMatchesAlgorithm(key, alg); err != nil {
+			matchErr = err
+			continue
+		}
+		token, verifyErr = jws.Verify([]byte(jwt), jws.WithKey(alg, key))
+		if verifyErr == nil {
+			break
+		}
+	}
+	if token == nil {
+		if verifyErr == nil {
+			return nil, errors.Mark(matchErr, errors.ErrKeyNotFound)
+		}
+		return nil, errors.Mark(verifyErr, errors.ErrInvalidSignature)
 	}
 
 	var claims interface{}
@@ -77,3 +205,217 @@ func (lgj *LestrratGoJwx) Decode(jwt string, jwkUri string) (interface{}, error)
 
 	return claims, nil
 }
+
+// Close stops background refreshes of the key set cache, if it has any.
+func (lgj *LestrratGoJwx) Close() error {
+	if closer, ok := lgj.jwkSetCache.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// keySetRefresh is a forced refresh of a key set in progress. Callers
+// looking for a kid in the same key set wait for it instead of starting
+// their own.
+type keySetRefresh struct {
+	done chan struct{}
+	set  jwk.Set
+	err  error
+	// cancelled is set when the fetch failed because the context of the
+	// caller running it was done; the callers waiting on it try again.
+	cancelled bool
+}
+
+// refreshForKid fetches the key set again when a token names a kid that is
+// not in the cached set, which is what happens right after the authorization
+// server rotates its keys. Refreshes are limited to one per
+// MinRefreshInterval, and kids still missing afterwards are remembered for
+// UnknownKidTimeout, so tokens with made-up kids cannot be used to flood the
+// authorization server with requests. Concurrent refreshes of one key set are
+// coalesced, and waiting for one gives up when ctx is done.
+func (lgj *LestrratGoJwx) refreshForKid(ctx context.Context, jwkUri string, kid string) (jwk.Key, error) {
+	notFound := errors.Mark(fmt.Errorf("no key with kid %q found in %s", kid, jwkUri), errors.ErrKeyNotFound)
+	unknownKey := jwkUri + " " + kid
+	if _, unknown := lgj.unknownKids.Get(unknownKey); unknown {
+		return nil, notFound
+	}
+
+	for {
+		// another caller may have refreshed the key set in the meantime
+		if value, err := utils.GetContext(ctx, lgj.jwkSetCache, jwkUri); err == nil {
+			if jwkSet, ok := value.(jwk.Set); ok {
+				if key, ok := jwkSet.LookupKeyID(kid); ok {
+					return key, nil
+				}
+			}
+		}
+		if key, ok := lgj.refreshedKey(jwkUri, kid); ok {
+			return key, nil
+		}
+
+		lgj.refreshMutex.Lock()
+		refresh, waiting := lgj.refreshing[jwkUri]
+		if !waiting {
+			if last, ok := lgj.lastRefresh[jwkUri]; ok && time.Since(last) < lgj.MinRefreshInterval {
+				lgj.refreshMutex.Unlock()
+				return nil, notFound
+			}
+			lgj.lastRefresh[jwkUri] = time.Now()
+			refresh = &keySetRefresh{done: make(chan struct{})}
+			lgj.refreshing[jwkUri] = refresh
+		}
+		lgj.refreshMutex.Unlock()
+
+		if waiting {
+			select {
+			case <-refresh.done:
+			case <-ctx.Done():
+				return nil, errors.Mark(ctx.Err(), errors.ErrKeySetUnavailable)
+			}
+			if refresh.cancelled && ctx.Err() == nil {
+				continue
+			}
+		} else {
+			lgj.refresh(ctx, jwkUri, refresh)
+		}
+
+		if refresh.err != nil {
+			return nil, refresh.err
+		}
+		if key, ok := refresh.set.LookupKeyID(kid); ok {
+			return key, nil
+		}
+		lgj.unknownKids.SetDefault(unknownKey, struct{}{})
+		return nil, notFound
+	}
+}
+
+// refresh fetches the key set at jwkUri for refreshForKid and hands the
+// result to the callers waiting on refresh.
+func (lgj *LestrratGoJwx) refresh(ctx context.Context, jwkUri string, refresh *keySetRefresh) {
+	refresh.err = errors.Mark(fmt.Errorf("refreshing the key set at %s panicked", jwkUri), errors.ErrKeySetUnavailable)
+	defer func() {
+		lgj.refreshMutex.Lock()
+		delete(lgj.refreshing, jwkUri)
+		if refresh.cancelled {
+			// a refresh cut short by its caller does not count against the
+			// rate limit
+			delete(lgj.lastRefresh, jwkUri)
+		}
+		lgj.refreshMutex.Unlock()
+		close(refresh.done)
+	}()
+
+	var value interface{}
+	var err error
+	refresher, canRefresh := lgj.jwkSetCache.(utils.Refresher)
+	if canRefresh {
+		value, err = refresher.Refresh(ctx, jwkUri)
+	} else {
+		value, err = lgj.fetchJwkSetContext(ctx, jwkUri)
+	}
+	if err != nil {
+		refresh.err = errors.Mark(err, errors.ErrKeySetUnavailable)
+		refresh.cancelled = ctx.Err() != nil
+		return
+	}
+
+	jwkSet, ok := value.(jwk.Set)
+	if !ok {
+		refresh.err = errors.Mark(fmt.Errorf("could not cast %v to jwk.Set", value), errors.ErrKeySetUnavailable)
+		return
+	}
+	// a cache without Refresh, such as one given through Cache, still holds
+	// the old set, so keep the new one for the tokens that follow
+	if !canRefresh {
+		lgj.refreshed.SetDefault(jwkUri, jwkSet)
+	}
+	refresh.set, refresh.err = jwkSet, nil
+}
+
+// refreshedKey looks kid up in the key set last fetched for jwkUri by a
+// forced refresh that could not be stored in the key set cache.
+func (lgj *LestrratGoJwx) refreshedKey(jwkUri string, kid string) (jwk.Key, bool) {
+	value, found := lgj.refreshed.Get(jwkUri)
+	if !found {
+		return nil, false
+	}
+	return value.(jwk.Set).LookupKeyID(kid)
+}
+
+func keysWithoutId(set jwk.Set) []jwk.Key {
+	var keys []jwk.Key
+	for i := 0; i < set.Len(); i++ {
+		if key, ok := set.Key(i); ok && key.KeyID() == "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// LestrratGoJwx implements the ContextAdaptor and io.Closer interfaces
+var (
+	_ adaptors.ContextAdaptor = (*LestrratGoJwx)(nil)
+	_ io.Closer               = (*LestrratGoJwx)(nil)
+)
+
+func (lgj *LestrratGoJwx) allowsAlgorithm(alg jwa.SignatureAlgorithm) bool {
+	algorithms := lgj.SigningAlgorithms
+	if len(algorithms) == 0 {
+		algorithms = []string{jwa.RS256.String()}
+	}
+	for _, allowed := range algorithms {
+		if allowed == alg.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// keyMatchesAlgorithm makes sure the key selected by the token's kid is of
+// the type its alg calls for, so a key published for one algorithm family can
+// never be used to verify a signature made with another.
+func keyMatchesAlgorithm(key jwk.Key, alg jwa.SignatureAlgorithm) error {
+	if keyAlg := key.Algorithm(); keyAlg != nil && keyAlg.String() != "" && keyAlg.String() != alg.String() {
+		return fmt.Errorf("key %q is for alg %s, not %s", key.KeyID(), keyAlg, alg)
+	}
+	if use := key.KeyUsage(); use != "" && use != string(jwk.ForSignature) {
+		return fmt.Errorf("key %q is not a signing key", key.KeyID())
+	}
+
+	var kty jwa.KeyType
+	var crv jwa.EllipticCurveAlgorithm
+	switch alg {
+	case jwa.RS256, jwa.RS384, jwa.RS512, jwa.PS256, jwa.PS384, jwa.PS512:
+		kty = jwa.RSA
+	case jwa.ES256:
+		kty, crv = jwa.EC, jwa.P256
+	case jwa.ES384:
+		kty, crv = jwa.EC, jwa.P384
+	case jwa.ES512:
+		kty, crv = jwa.EC, jwa.P521
+	case jwa.EdDSA:
+		kty, crv = jwa.OKP, jwa.Ed25519
+	default:
+		return fmt.Errorf("alg %s is not supported", alg)
+	}
+
+	if key.KeyType() != kty {
+		return fmt.Errorf("key %q has kty %s which cannot be used with alg %s", key.KeyID(), key.KeyType(), alg)
+	}
+	if crv == "" {
+		return nil
+	}
+
+	var keyCrv jwa.EllipticCurveAlgorithm
+	switch k := key.(type) {
+	case jwk.ECDSAPublicKey:
+		keyCrv = k.Crv()
+	case jwk.OKPPublicKey:
+		keyCrv = k.Crv()
+	}
+	if keyCrv != crv {
+		return fmt.Errorf("key %q has crv %s which cannot be used with alg %s", key.KeyID(), keyCrv, alg)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package jwtverifier
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+)
+
+// WithMaxAge requires the user to have authenticated at most maxAge ago,
+// going by the auth_time claim, which must be present.
+func WithMaxAge(maxAge time.Duration) VerifyOption {
+	return func(o *verifyOptions) {
+		o.maxAge = maxAge
+	}
+}
+
+// WithAcrValues requires the acr claim to be one of values.
+func WithAcrValues(values ...string) VerifyOption {
+	return func(o *verifyOptions) {
+		o.acrValues = append(o.acrValues, values...)
+	}
+}
+
+// WithRequiredAmr requires the amr claim to list every one of methods, such
+// as "mfa" or "hwk".
+func WithRequiredAmr(methods ...string) VerifyOption {
+	return func(o *verifyOptions) {
+		o.requiredAmr = append(o.requiredAmr, methods...)
+	}
+}
+
+// validateAuthentication checks the auth_time, acr and amr claims against the
+// options. leeway is in seconds.
+func (o *verifyOptions) validateAuthentication(claims map[string]interface{}, leeway int64) error {
+	if o.maxAge > 0 {
+		authTime, ok := claims["auth_time"].(float64)
+		if !ok {
+			return o.insufficientAuthentication(errors.ErrAuthenticationTooOld, "auth_time: missing")
+		}
+		oldest := time.Now().Add(-o.maxAge).Unix() - leeway
+		if int64(authTime) < oldest {
+			return o.insufficientAuthentication(errors.ErrAuthenticationTooOld,
+				fmt.Sprintf("auth_time: the user authenticated more than %s ago", o.maxAge))
+		}
+	}
+
+	if len(o.acrValues) > 0 {
+		acr, _ := claims["acr"].(string)
+		accepted := false
+		for _, value := range o.acrValues {
+			if acr == value {
+				accepted = true
+				break
+			}
+		}
+		if !accepted {
+			return o.insufficientAuthentication(errors.ErrAcrNotAccepted,
+				fmt.Sprintf("acr: %s is not one of %s", acr, strings.Join(o.acrValues, ", ")))
+		}
+	}
+
+	if len(o.requiredAmr) > 0 {
+		methods := map[string]bool{}
+		switch amr := claims["amr"].(type) {
+		case []interface{}:
+			for _, m := range amr {
+				if method, ok := m.(string); ok {
+					methods[method] = true
+				}
+			}
+		case []string:
+			for _, method := range amr {
+				methods[method] = true
+			}
+		}
+		var missing []string
+		for _, method := range o.requiredAmr {
+			if !methods[method] {
+				missing = append(missing, method)
+			}
+		}
+		if len(missing) > 0 {
+			return o.insufficientAuthentication(errors.ErrAmrMissing,
+				fmt.Sprintf("amr: missing %s", strings.Join(missing, ", ")))
+		}
+	}
+	return nil
+}
+
+func (o *verifyOptions) insufficientAuthentication(kind error, message string) error {
+	return &errors.InsufficientUserAuthentication{
+		Kind:      kind,
+		Message:   message,
+		MaxAge:    o.maxAge,
+		AcrValues: o.acrValues,
+	}
+}
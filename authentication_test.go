@@ -0,0 +1,94 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package jwtverifier
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	oktaErrors "github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+	"github.com/jarcoal/httpmock"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthenticationRequirements(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	key := newSigningKey(t, "key", jwa.RS256)
+	mockIssuer(t, issuer, key)
+
+	verifier, err := (&JwtVerifier{
+		Issuer:           issuer,
+		ClaimsToValidate: map[string]string{"nonce": "nonce"},
+	}).New()
+	require.NoError(t, err)
+
+	idToken := func(extra map[string]interface{}) string {
+		claims := validClaims(issuer)
+		claims["nonce"] = "nonce"
+		for k, v := range extra {
+			claims[k] = v
+		}
+		return signToken(t, key, jwa.RS256, nil, claims)
+	}
+
+	fresh := idToken(map[string]interface{}{
+		"auth_time": time.Now().Add(-time.Minute).Unix(),
+		"acr":       "urn:okta:loa:2fa:any",
+		"amr":       []string{"pwd", "mfa", "hwk"},
+	})
+	_, err = verifier.VerifyIdToken(fresh,
+		WithMaxAge(5*time.Minute),
+		WithAcrValues("urn:okta:loa:2fa:any", "urn:okta:loa:2fa:any:ifpossible"),
+		WithRequiredAmr("mfa", "hwk"))
+	require.NoError(t, err)
+
+	// auth_time is allowed the leeway
+	_, err = verifier.VerifyIdToken(idToken(map[string]interface{}{"auth_time": time.Now().Add(-6 * time.Minute).Unix()}),
+		WithMaxAge(5*time.Minute))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		token string
+		opt   VerifyOption
+		kind  error
+	}{
+		{"stale authentication", idToken(map[string]interface{}{"auth_time": time.Now().Add(-time.Hour).Unix()}), WithMaxAge(5 * time.Minute), oktaErrors.ErrAuthenticationTooOld},
+		{"missing auth_time", idToken(nil), WithMaxAge(5 * time.Minute), oktaErrors.ErrAuthenticationTooOld},
+		{"acr not accepted", idToken(map[string]interface{}{"acr": "urn:okta:loa:1fa:any"}), WithAcrValues("urn:okta:loa:2fa:any"), oktaErrors.ErrAcrNotAccepted},
+		{"missing amr", idToken(map[string]interface{}{"amr": []string{"pwd"}}), WithRequiredAmr("mfa"), oktaErrors.ErrAmrMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifier.VerifyIdToken(tt.token, tt.opt)
+			require.ErrorIs(t, err, tt.kind)
+			require.ErrorIs(t, err, oktaErrors.ErrInsufficientUserAuthentication)
+			require.False(t, errors.Is(err, oktaErrors.ErrInvalidToken))
+		})
+	}
+
+	_, err = verifier.VerifyIdToken(idToken(nil), WithMaxAge(5*time.Minute), WithAcrValues("urn:okta:loa:2fa:any"))
+	var insufficient *oktaErrors.InsufficientUserAuthentication
+	require.ErrorAs(t, err, &insufficient)
+	require.Equal(t, 5*time.Minute, insufficient.MaxAge)
+	require.Equal(t, []string{"urn:okta:loa:2fa:any"}, insufficient.AcrValues)
+}
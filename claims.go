@@ -0,0 +1,183 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package jwtverifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// OktaAccessTokenClaims holds the standard claims of an Okta access token.
+type OktaAccessTokenClaims struct {
+	Issuer    string    `json:"iss"`
+	Subject   string    `json:"sub"`
+	Audience  []string  `json:"aud"`
+	ExpiresAt time.Time `json:"exp"`
+	IssuedAt  time.Time `json:"iat"`
+	ID        string    `json:"jti"`
+	ClientID  string    `json:"cid"`
+	UserID    string    `json:"uid"`
+	Scopes    []string  `json:"scp"`
+	AuthTime  time.Time `json:"auth_time"`
+	Version   int       `json:"ver"`
+}
+
+func (c *OktaAccessTokenClaims) UnmarshalJSON(data []byte) error {
+	type alias OktaAccessTokenClaims
+	aux := struct {
+		*alias
+		Audience  stringList  `json:"aud"`
+		ExpiresAt numericDate `json:"exp"`
+		IssuedAt  numericDate `json:"iat"`
+		Scopes    stringList  `json:"scp"`
+		AuthTime  numericDate `json:"auth_time"`
+	}{alias: (*alias)(c)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	c.Audience = aux.Audience
+	c.ExpiresAt = aux.ExpiresAt.Time
+	c.IssuedAt = aux.IssuedAt.Time
+	c.Scopes = aux.Scopes
+	c.AuthTime = aux.AuthTime.Time
+	return nil
+}
+
+// OktaIDTokenClaims holds the standard claims of an Okta ID token.
+type OktaIDTokenClaims struct {
+	Issuer            string    `json:"iss"`
+	Subject           string    `json:"sub"`
+	Audience          []string  `json:"aud"`
+	ExpiresAt         time.Time `json:"exp"`
+	IssuedAt          time.Time `json:"iat"`
+	ID                string    `json:"jti"`
+	AuthTime          time.Time `json:"auth_time"`
+	Nonce             string    `json:"nonce"`
+	AuthMethods       []string  `json:"amr"`
+	IdentityProvider  string    `json:"idp"`
+	AccessTokenHash   string    `json:"at_hash"`
+	Name              string    `json:"name"`
+	Email             string    `json:"email"`
+	PreferredUsername string    `json:"preferred_username"`
+	Groups            []string  `json:"groups"`
+	Version           int       `json:"ver"`
+}
+
+func (c *OktaIDTokenClaims) UnmarshalJSON(data []byte) error {
+	type alias OktaIDTokenClaims
+	aux := struct {
+		*alias
+		Audience    stringList  `json:"aud"`
+		ExpiresAt   numericDate `json:"exp"`
+		IssuedAt    numericDate `json:"iat"`
+		AuthTime    numericDate `json:"auth_time"`
+		AuthMethods stringList  `json:"amr"`
+		Groups      stringList  `json:"groups"`
+	}{alias: (*alias)(c)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	c.Audience = aux.Audience
+	c.ExpiresAt = aux.ExpiresAt.Time
+	c.IssuedAt = aux.IssuedAt.Time
+	c.AuthTime = aux.AuthTime.Time
+	c.AuthMethods = aux.AuthMethods
+	c.Groups = aux.Groups
+	return nil
+}
+
+// Decode unmarshals the verified claims into v, which must be a pointer to a
+// value that encoding/json can decode into.
+func (j *Jwt) Decode(v interface{}) error {
+	payload, err := json.Marshal(j.Claims)
+	if err != nil {
+		return fmt.Errorf("could not marshal claims: %w", err)
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return fmt.Errorf("could not decode claims: %w", err)
+	}
+	return nil
+}
+
+// VerifyAccessTokenInto verifies an access token and decodes its claims into
+// a new T.
+func VerifyAccessTokenInto[T any](ctx context.Context, j *JwtVerifier, jwt string, opts ...VerifyOption) (*T, error) {
+	token, err := j.VerifyAccessTokenContext(ctx, jwt, opts...)
+	if err != nil {
+		return nil, err
+	}
+	claims := new(T)
+	if err := token.Decode(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// VerifyIdTokenInto verifies an ID token and decodes its claims into a new T.
+func VerifyIdTokenInto[T any](ctx context.Context, j *JwtVerifier, jwt string, opts ...VerifyOption) (*T, error) {
+	token, err := j.VerifyIdTokenContext(ctx, jwt, opts...)
+	if err != nil {
+		return nil, err
+	}
+	claims := new(T)
+	if err := token.Decode(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// numericDate decodes a JWT NumericDate (seconds since the epoch).
+type numericDate struct {
+	time.Time
+}
+
+func (d *numericDate) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err != nil {
+		return fmt.Errorf("could not decode NumericDate: %w", err)
+	}
+	whole, frac := math.Modf(seconds)
+	d.Time = time.Unix(int64(whole), int64(frac*1e9))
+	return nil
+}
+
+// stringList decodes a claim that may be either a single string or an array
+// of strings, such as aud.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*l = stringList{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("could not decode string list: %w", err)
+	}
+	*l = list
+	return nil
+}
@@ -0,0 +1,94 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package jwtverifier
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJwtDecodeIntoOktaAccessTokenClaims(t *testing.T) {
+	token := Jwt{Claims: map[string]interface{}{
+		"iss": "https://example.com/oauth2/default",
+		"sub": "user@example.com",
+		"aud": "api://default",
+		"exp": float64(1700003600),
+		"iat": float64(1700000000),
+		"cid": "client-id",
+		"scp": []interface{}{"openid", "profile"},
+		"ver": float64(1),
+	}}
+
+	var claims OktaAccessTokenClaims
+	require.NoError(t, token.Decode(&claims))
+
+	require.Equal(t, "user@example.com", claims.Subject)
+	require.Equal(t, []string{"api://default"}, claims.Audience)
+	require.Equal(t, []string{"openid", "profile"}, claims.Scopes)
+	require.True(t, claims.ExpiresAt.Equal(time.Unix(1700003600, 0)))
+	require.True(t, claims.IssuedAt.Equal(time.Unix(1700000000, 0)))
+	require.True(t, claims.AuthTime.IsZero())
+	require.Equal(t, "client-id", claims.ClientID)
+	require.Equal(t, 1, claims.Version)
+}
+
+func TestJwtDecodeIntoCustomStruct(t *testing.T) {
+	type customClaims struct {
+		Subject string   `json:"sub"`
+		Groups  []string `json:"groups"`
+		Tenant  string   `json:"tenant"`
+	}
+
+	token := Jwt{Claims: map[string]interface{}{
+		"sub":    "user@example.com",
+		"groups": []interface{}{"admins"},
+		"tenant": "acme",
+	}}
+
+	var claims customClaims
+	require.NoError(t, token.Decode(&claims))
+	require.Equal(t, customClaims{Subject: "user@example.com", Groups: []string{"admins"}, Tenant: "acme"}, claims)
+}
+
+func TestVerifyIdTokenInto(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	key := newSigningKey(t, "rsa", jwa.RS256)
+	mockIssuer(t, issuer, key)
+
+	tokenClaims := validClaims(issuer)
+	tokenClaims["aud"] = []string{"client-id"}
+	tokenClaims["amr"] = []string{"pwd", "mfa"}
+
+	jvs := JwtVerifier{Issuer: issuer}
+	jv, err := jvs.New()
+	require.NoError(t, err)
+
+	claims, err := VerifyIdTokenInto[OktaIDTokenClaims](context.Background(), jv, signToken(t, key, jwa.RS256, nil, tokenClaims))
+	require.NoError(t, err)
+	require.Equal(t, issuer, claims.Issuer)
+	require.Equal(t, []string{"client-id"}, claims.Audience)
+	require.Equal(t, []string{"pwd", "mfa"}, claims.AuthMethods)
+	require.False(t, claims.ExpiresAt.IsZero())
+}
@@ -20,3 +20,12 @@ type Discovery interface {
 	New() Discovery
 	GetWellKnownUrl() string
 }
+
+// IssuerDiscovery is implemented by discoveries whose metadata location
+// depends on the shape of the issuer, not just on a suffix appended to it.
+// The verifier tries the returned URLs in order and uses the first that
+// answers.
+type IssuerDiscovery interface {
+	Discovery
+	GetMetadataUrls(issuer string) []string
+}
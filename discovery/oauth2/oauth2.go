@@ -0,0 +1,58 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package oauth2
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/discovery"
+)
+
+// OAuth2 discovers OAuth 2.0 authorization server metadata (RFC 8414).
+type OAuth2 struct {
+	wellKnownUrl string
+}
+
+func (d OAuth2) New() discovery.Discovery {
+	d.wellKnownUrl = "/.well-known/oauth-authorization-server"
+	return d
+}
+
+func (d OAuth2) GetWellKnownUrl() string {
+	return d.wellKnownUrl
+}
+
+// GetMetadataUrls returns where the metadata of issuer may be found. For an
+// issuer with a path, RFC 8414 inserts the well-known suffix between the host
+// and the path, while Okta also serves it appended to the issuer; the RFC 8414
+// form is tried first.
+func (d OAuth2) GetMetadataUrls(issuer string) []string {
+	issuer = strings.TrimRight(issuer, "/")
+	appended := issuer + d.GetWellKnownUrl()
+
+	u, err := url.Parse(issuer)
+	if err != nil || u.Path == "" {
+		return []string{appended}
+	}
+	path := u.EscapedPath()
+	u.Path, u.RawPath = "", ""
+	return []string{u.String() + d.GetWellKnownUrl() + path, appended}
+}
+
+// OAuth2 implements the IssuerDiscovery interface
+var _ discovery.IssuerDiscovery = OAuth2{}
@@ -0,0 +1,47 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package oauth2_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/discovery"
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/discovery/oauth2"
+)
+
+func TestGetMetadataUrls(t *testing.T) {
+	disc := oauth2.OAuth2{}.New().(discovery.IssuerDiscovery)
+
+	tests := map[string][]string{
+		"https://example.com": {
+			"https://example.com/.well-known/oauth-authorization-server",
+		},
+		"https://example.com/": {
+			"https://example.com/.well-known/oauth-authorization-server",
+		},
+		"https://example.com/oauth2/default": {
+			"https://example.com/.well-known/oauth-authorization-server/oauth2/default",
+			"https://example.com/oauth2/default/.well-known/oauth-authorization-server",
+		},
+	}
+	for issuer, expected := range tests {
+		if urls := disc.GetMetadataUrls(issuer); !reflect.DeepEqual(urls, expected) {
+			t.Errorf("GetMetadataUrls(%q) = %v, expected %v", issuer, urls, expected)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package jwtverifier
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+// dpopProof holds the validated contents of a DPoP proof.
+type dpopProof struct {
+	jti        string
+	iat        time.Time
+	ath        string
+	thumbprint string
+}
+
+// VerifyDPoP verifies a DPoP-bound access token (RFC 9449) together with the
+// DPoP proof sent with it. method and url are those of the HTTP request the
+// proof was sent with; the query and fragment of url are ignored.
+//
+// The access token is verified like VerifyAccessTokenContext does, except
+// that its cnf.jkt claim must match the thumbprint of the proof's key before
+// the RevocationChecker and ReplayStore are consulted, so a stolen token sent
+// with someone else's proof is rejected without being recorded. Every proof
+// is accepted only once; its jti is recorded in ReplayStore.
+func (j *JwtVerifier) VerifyDPoP(ctx context.Context, accessToken, proof, method, url string, opts ...VerifyOption) (*Jwt, error) {
+	p, err := j.parseDPoPProof(proof, method, url)
+	if err != nil {
+		return nil, fmt.Errorf("the DPoP proof is not valid: %w", err)
+	}
+
+	myJwt, err := j.verifyAccessToken(ctx, accessToken, opts, func(myJwt *Jwt) error {
+		return p.bind(accessToken, myJwt)
+	})
+	if err != nil {
+		return myJwt, err
+	}
+
+	until := p.iat.Add(j.dpopMaxAge() + time.Duration(j.leeway)*time.Second)
+	stored, err := j.ReplayStore.Store(ctx, "dpop#"+p.jti, until)
+	if err != nil {
+		return myJwt, errors.Mark(fmt.Errorf("could not record DPoP proof jti: %w", err), errors.ErrReplayStoreUnavailable)
+	}
+	if !stored {
+		return myJwt, fmt.Errorf("the DPoP proof is not valid: %w",
+			errors.Mark(fmt.Errorf("jti: %s has already been used", p.jti), errors.ErrTokenReplayed))
+	}
+
+	return myJwt, nil
+}
+
+// bind checks that the proof was made for accessToken and with the key the
+// token is bound to.
+func (p *dpopProof) bind(accessToken string, myJwt *Jwt) error {
+	sum := sha256.Sum256([]byte(accessToken))
+	ath := base64.RawURLEncoding.EncodeToString(sum[:])
+	if subtle.ConstantTimeCompare([]byte(p.ath), []byte(ath)) != 1 {
+		return fmt.Errorf("the DPoP proof is not valid: %w",
+			errors.Mark(fmt.Errorf("ath: does not match the access token"), errors.ErrInvalidDPoPProof))
+	}
+
+	cnf, _ := myJwt.Claims["cnf"].(map[string]interface{})
+	jkt, _ := cnf["jkt"].(string)
+	if jkt == "" {
+		return errors.Mark(fmt.Errorf("the access token is not DPoP bound: cnf.jkt: missing"), errors.ErrTokenBindingMismatch)
+	}
+	if subtle.ConstantTimeCompare([]byte(jkt), []byte(p.thumbprint)) != 1 {
+		return errors.Mark(fmt.Errorf("cnf.jkt: %s does not match the DPoP proof key %s", jkt, p.thumbprint), errors.ErrTokenBindingMismatch)
+	}
+	return nil
+}
+
+// parseDPoPProof checks the signature and claims of a DPoP proof, except
+// for those binding it to the access token.
+func (j *JwtVerifier) parseDPoPProof(proof, method, requestUrl string) (*dpopProof, error) {
+	if proof == "" {
+		return nil, errors.Mark(fmt.Errorf("no DPoP proof present"), errors.ErrInvalidDPoPProof)
+	}
+	msg, err := jws.Parse([]byte(proof))
+	if err != nil {
+		return nil, errors.Mark(fmt.Errorf("could not parse DPoP proof: %w", err), errors.ErrInvalidDPoPProof)
+	}
+	if len(msg.Signatures()) != 1 {
+		return nil, errors.Mark(fmt.Errorf("DPoP proof must have exactly one signature"), errors.ErrInvalidDPoPProof)
+	}
+	headers := msg.Signatures()[0].ProtectedHeaders()
+
+	if headers.Type() != "dpop+jwt" {
+		return nil, errors.Mark(fmt.Errorf("typ: %s is not dpop+jwt", headers.Type()), errors.ErrInvalidDPoPProof)
+	}
+	alg := headers.Algorithm()
+	if !supportedSigningAlgorithms[alg.String()] {
+		return nil, errors.Mark(fmt.Errorf("alg: %s is not supported for DPoP proofs", alg), errors.ErrInvalidDPoPProof)
+	}
+	key := headers.JWK()
+	if key == nil {
+		return nil, errors.Mark(fmt.Errorf("jwk: missing"), errors.ErrInvalidDPoPProof)
+	}
+	switch key.(type) {
+	case jwk.RSAPublicKey, jwk.ECDSAPublicKey, jwk.OKPPublicKey:
+	default:
+		return nil, errors.Mark(fmt.Errorf("jwk: must be a public key"), errors.ErrInvalidDPoPProof)
+	}
+
+	payload, err := jws.Verify([]byte(proof), jws.WithKey(alg, key))
+	if err != nil {
+		return nil, errors.Mark(fmt.Errorf("could not verify DPoP proof signature: %w", err), errors.ErrInvalidDPoPProof)
+	}
+
+	var claims struct {
+		Jti string      `json:"jti"`
+		Htm string      `json:"htm"`
+		Htu string      `json:"htu"`
+		Iat json.Number `json:"iat"`
+		Ath string      `json:"ath"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.Mark(fmt.Errorf("could not decode DPoP proof claims: %w", err), errors.ErrInvalidDPoPProof)
+	}
+
+	if claims.Jti == "" {
+		return nil, errors.Mark(fmt.Errorf("jti: missing"), errors.ErrInvalidDPoPProof)
+	}
+	if claims.Htm != method {
+		return nil, errors.Mark(fmt.Errorf("htm: %s does not match %s", claims.Htm, method), errors.ErrInvalidDPoPProof)
+	}
+	if !sameTargetUri(claims.Htu, requestUrl) {
+		return nil, errors.Mark(fmt.Errorf("htu: %s does not match %s", claims.Htu, requestUrl), errors.ErrInvalidDPoPProof)
+	}
+
+	iatf, err := claims.Iat.Float64()
+	if err != nil {
+		return nil, errors.Mark(fmt.Errorf("iat: missing"), errors.ErrInvalidDPoPProof)
+	}
+	iat := time.Unix(int64(iatf), 0)
+	leeway := time.Duration(j.leeway) * time.Second
+	now := time.Now()
+	if iat.After(now.Add(leeway)) {
+		return nil, errors.Mark(fmt.Errorf("the DPoP proof was issued in the future"), errors.ErrInvalidDPoPProof)
+	}
+	if iat.Before(now.Add(-j.dpopMaxAge() - leeway)) {
+		return nil, errors.Mark(fmt.Errorf("the DPoP proof is too old"), errors.ErrInvalidDPoPProof)
+	}
+
+	if claims.Ath == "" {
+		return nil, errors.Mark(fmt.Errorf("ath: missing"), errors.ErrInvalidDPoPProof)
+	}
+
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, errors.Mark(fmt.Errorf("could not compute jwk thumbprint: %w", err), errors.ErrInvalidDPoPProof)
+	}
+
+	return &dpopProof{
+		jti:        claims.Jti,
+		iat:        iat,
+		ath:        claims.Ath,
+		thumbprint: base64.RawURLEncoding.EncodeToString(thumbprint),
+	}, nil
+}
+
+func (j *JwtVerifier) dpopMaxAge() time.Duration {
+	if j.DPoPMaxAge > 0 {
+		return j.DPoPMaxAge
+	}
+	return 5 * time.Minute
+}
+
+// sameTargetUri compares two URIs as RFC 9449 section 4.3 requires: without
+// their query and fragment, and after normalizing the scheme, host and port.
+func sameTargetUri(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return normalizeTargetUri(ua) == normalizeTargetUri(ub)
+}
+
+func normalizeTargetUri(u *url.URL) string {
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if (scheme == "https" && port == "443") || (scheme == "http" && port == "80") {
+		port = ""
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return scheme + "://" + host + path
+}
@@ -0,0 +1,168 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package jwtverifier
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	oktaErrors "github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+	"github.com/jarcoal/httpmock"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/stretchr/testify/require"
+)
+
+func newDPoPProof(t *testing.T, key jwk.Key, accessToken string, overrides map[string]interface{}) string {
+	t.Helper()
+
+	pub, err := jwk.PublicKeyOf(key)
+	require.NoError(t, err)
+
+	sum := sha256.Sum256([]byte(accessToken))
+	claims := map[string]interface{}{
+		"jti": "proof-" + time.Now().Format(time.RFC3339Nano),
+		"htm": "POST",
+		"htu": "https://api.example.com/orders",
+		"iat": time.Now().Unix(),
+		"ath": base64.RawURLEncoding.EncodeToString(sum[:]),
+	}
+	for k, v := range overrides {
+		if v == nil {
+			delete(claims, k)
+			continue
+		}
+		claims[k] = v
+	}
+	return signToken(t, key, jwa.ES256, map[string]interface{}{"typ": "dpop+jwt", "jwk": pub}, claims)
+}
+
+func jwkThumbprint(t *testing.T, key jwk.Key) string {
+	t.Helper()
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	require.NoError(t, err)
+	return base64.RawURLEncoding.EncodeToString(thumbprint)
+}
+
+func TestVerifyDPoP(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	key := newSigningKey(t, "key", jwa.RS256)
+	mockIssuer(t, issuer, key)
+
+	verifier, err := (&JwtVerifier{Issuer: issuer}).New()
+	require.NoError(t, err)
+
+	clientKey := newSigningKey(t, "client", jwa.ES256)
+	otherKey := newSigningKey(t, "other", jwa.ES256)
+
+	claims := validClaims(issuer)
+	claims["cnf"] = map[string]interface{}{"jkt": jwkThumbprint(t, clientKey)}
+	accessToken := signToken(t, key, jwa.RS256, nil, claims)
+	unbound := signToken(t, key, jwa.RS256, nil, validClaims(issuer))
+
+	ctx := context.Background()
+	url := "https://API.example.com:443/orders?page=2"
+
+	proof := newDPoPProof(t, clientKey, accessToken, map[string]interface{}{"jti": "first"})
+	jwt, err := verifier.VerifyDPoP(ctx, accessToken, proof, "POST", url)
+	require.NoError(t, err)
+	require.Equal(t, "user@example.com", jwt.Claims["sub"])
+
+	// proofs are single use
+	_, err = verifier.VerifyDPoP(ctx, accessToken, proof, "POST", url)
+	require.ErrorIs(t, err, oktaErrors.ErrTokenReplayed)
+
+	tests := []struct {
+		name        string
+		accessToken string
+		proof       string
+		method      string
+		err         error
+	}{
+		{"missing proof", accessToken, "", "POST", oktaErrors.ErrInvalidDPoPProof},
+		{"wrong method", accessToken, newDPoPProof(t, clientKey, accessToken, nil), "GET", oktaErrors.ErrInvalidDPoPProof},
+		{"wrong url", accessToken, newDPoPProof(t, clientKey, accessToken, map[string]interface{}{"htu": "https://api.example.com/users"}), "POST", oktaErrors.ErrInvalidDPoPProof},
+		{"too old", accessToken, newDPoPProof(t, clientKey, accessToken, map[string]interface{}{"iat": time.Now().Add(-time.Hour).Unix()}), "POST", oktaErrors.ErrInvalidDPoPProof},
+		{"issued in the future", accessToken, newDPoPProof(t, clientKey, accessToken, map[string]interface{}{"iat": time.Now().Add(time.Hour).Unix()}), "POST", oktaErrors.ErrInvalidDPoPProof},
+		{"missing jti", accessToken, newDPoPProof(t, clientKey, accessToken, map[string]interface{}{"jti": nil}), "POST", oktaErrors.ErrInvalidDPoPProof},
+		{"ath of another token", accessToken, newDPoPProof(t, clientKey, unbound, nil), "POST", oktaErrors.ErrInvalidDPoPProof},
+		{"wrong typ", accessToken, signToken(t, clientKey, jwa.ES256, map[string]interface{}{"typ": "JWT"}, map[string]interface{}{}), "POST", oktaErrors.ErrInvalidDPoPProof},
+		{"key of another client", accessToken, newDPoPProof(t, otherKey, accessToken, nil), "POST", oktaErrors.ErrTokenBindingMismatch},
+		{"unbound access token", unbound, newDPoPProof(t, clientKey, unbound, nil), "POST", oktaErrors.ErrTokenBindingMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifier.VerifyDPoP(ctx, tt.accessToken, tt.proof, tt.method, url)
+			require.ErrorIs(t, err, tt.err)
+			require.ErrorIs(t, err, oktaErrors.ErrInvalidToken)
+		})
+	}
+}
+
+func TestDPoPProofMustNotCarryAPrivateKey(t *testing.T) {
+	verifier, err := (&JwtVerifier{Issuer: "https://example.com/oauth2/default"}).New()
+	require.NoError(t, err)
+
+	clientKey := newSigningKey(t, "client", jwa.ES256)
+	proof := signToken(t, clientKey, jwa.ES256, map[string]interface{}{"typ": "dpop+jwt", "jwk": clientKey}, map[string]interface{}{})
+	_, err = verifier.parseDPoPProof(proof, "POST", "https://api.example.com/orders")
+	require.ErrorIs(t, err, oktaErrors.ErrInvalidDPoPProof)
+	require.ErrorContains(t, err, "must be a public key")
+}
+
+func TestDPoPBindingIsCheckedBeforeTheTokenIsRecorded(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	key := newSigningKey(t, "key", jwa.RS256)
+	mockIssuer(t, issuer, key)
+
+	clientKey := newSigningKey(t, "client", jwa.ES256)
+	attackerKey := newSigningKey(t, "attacker", jwa.ES256)
+	claims := validClaims(issuer)
+	claims["jti"] = "one-time"
+	claims["cnf"] = map[string]interface{}{"jkt": jwkThumbprint(t, clientKey)}
+	accessToken := signToken(t, key, jwa.RS256, nil, claims)
+
+	ctx := context.Background()
+	url := "https://api.example.com/orders"
+
+	verifier, err := (&JwtVerifier{Issuer: issuer, ReplayProtection: true}).New()
+	require.NoError(t, err)
+
+	// a stolen token sent with the attacker's own proof must not burn its jti
+	_, err = verifier.VerifyDPoP(ctx, accessToken, newDPoPProof(t, attackerKey, accessToken, nil), "POST", url)
+	require.ErrorIs(t, err, oktaErrors.ErrTokenBindingMismatch)
+
+	_, err = verifier.VerifyDPoP(ctx, accessToken, newDPoPProof(t, clientKey, accessToken, nil), "POST", url)
+	require.NoError(t, err)
+
+	// nor reach the RevocationChecker
+	unavailable, err := (&JwtVerifier{Issuer: issuer, RevocationChecker: failingRevocationChecker{}}).New()
+	require.NoError(t, err)
+	_, err = unavailable.VerifyDPoP(ctx, accessToken, newDPoPProof(t, attackerKey, accessToken, nil), "POST", url)
+	require.ErrorIs(t, err, oktaErrors.ErrTokenBindingMismatch)
+	require.NotErrorIs(t, err, oktaErrors.ErrRevocationUnavailable)
+}
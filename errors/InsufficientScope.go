@@ -0,0 +1,42 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package errors
+
+import (
+	"fmt"
+	"strings"
+)
+
+// InsufficientScope is returned when a token does not grant the scopes a
+// request requires. It matches ErrInsufficientScope.
+type InsufficientScope struct {
+	// Missing lists the required scopes the token does not grant. When AnyOf
+	// is true none of them were granted, and any one would have sufficed.
+	Missing []string
+	AnyOf   bool
+}
+
+func (e *InsufficientScope) Error() string {
+	if e.AnyOf {
+		return fmt.Sprintf("the token grants none of the scopes %s", strings.Join(e.Missing, ", "))
+	}
+	return fmt.Sprintf("the token is missing the scopes %s", strings.Join(e.Missing, ", "))
+}
+
+func (e *InsufficientScope) Is(target error) bool {
+	return target == ErrInsufficientScope
+}
@@ -0,0 +1,44 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package errors
+
+import (
+	stderrors "errors"
+	"time"
+)
+
+// InsufficientUserAuthentication is returned when the authentication event
+// behind a token does not meet the requirements of a request (RFC 9470). It
+// matches ErrInsufficientUserAuthentication and its Kind, one of
+// ErrAuthenticationTooOld, ErrAcrNotAccepted and ErrAmrMissing.
+type InsufficientUserAuthentication struct {
+	Kind    error
+	Message string
+
+	// MaxAge and AcrValues are the requirements the client has to meet when
+	// authenticating the user again.
+	MaxAge    time.Duration
+	AcrValues []string
+}
+
+func (e *InsufficientUserAuthentication) Error() string {
+	return e.Message
+}
+
+func (e *InsufficientUserAuthentication) Is(target error) bool {
+	return stderrors.Is(e.Kind, target)
+}
@@ -16,6 +16,8 @@
 
 package errors
 
+import stderrors "errors"
+
 type JwtEmptyString struct {
 	message string
 }
@@ -29,3 +31,7 @@ func JwtEmptyStringError() *JwtEmptyString {
 func (e *JwtEmptyString) Error() string {
 	return e.message
 }
+
+func (e *JwtEmptyString) Is(target error) bool {
+	return stderrors.Is(ErrMalformedToken, target)
+}
@@ -0,0 +1,124 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package errors
+
+import stderrors "errors"
+
+// ErrInvalidToken is matched by every error caused by the token itself. A
+// caller seeing it should answer 401.
+var ErrInvalidToken = stderrors.New("invalid token")
+
+// ErrUnavailable is matched by every error caused by the verifier being
+// unable to reach the resources it needs, such as the authorization server's
+// metadata or keys. A caller seeing it should answer 503 and alert.
+var ErrUnavailable = stderrors.New("verification resources unavailable")
+
+// Errors caused by the token. Each of them also matches ErrInvalidToken.
+var (
+	ErrMalformedToken          = newKind("malformed token", ErrInvalidToken)
+	ErrInvalidTokenType        = newKind("invalid token type", ErrInvalidToken)
+	ErrUnsupportedAlg          = newKind("unsupported signing algorithm", ErrInvalidToken)
+	ErrUnsupportedEncryption   = newKind("unsupported encryption algorithm", ErrInvalidToken)
+	ErrDecryptionFailed        = newKind("token could not be decrypted", ErrInvalidToken)
+	ErrKeyNotFound             = newKind("no usable key found for token", ErrInvalidToken)
+	ErrInvalidSignature        = newKind("invalid token signature", ErrInvalidToken)
+	ErrMissingClaim            = newKind("missing claim", ErrInvalidToken)
+	ErrTokenExpired            = newKind("token expired", ErrInvalidToken)
+	ErrIssuedInFuture          = newKind("token issued in the future", ErrInvalidToken)
+	ErrTokenNotYetValid        = newKind("token not yet valid", ErrInvalidToken)
+	ErrIssuerMismatch          = newKind("issuer mismatch", ErrInvalidToken)
+	ErrAudienceMismatch        = newKind("audience mismatch", ErrInvalidToken)
+	ErrClientIdMismatch        = newKind("client id mismatch", ErrInvalidToken)
+	ErrAuthorizedPartyMismatch = newKind("authorized party mismatch", ErrInvalidToken)
+	ErrNonceMismatch           = newKind("nonce mismatch", ErrInvalidToken)
+	ErrTokenInactive           = newKind("token inactive", ErrInvalidToken)
+	ErrTokenRevoked            = newKind("token revoked", ErrInvalidToken)
+	ErrTokenReplayed           = newKind("token replayed", ErrInvalidToken)
+	ErrInvalidDPoPProof        = newKind("invalid DPoP proof", ErrInvalidToken)
+	ErrTokenBindingMismatch    = newKind("token binding mismatch", ErrInvalidToken)
+	ErrTokenHashMismatch       = newKind("token hash mismatch", ErrInvalidToken)
+)
+
+// ErrInsufficientScope is returned when a valid token lacks a scope required
+// for the request. A caller seeing it should answer 403.
+var ErrInsufficientScope = newKind("insufficient scope", nil)
+
+// ErrInsufficientUserAuthentication is returned when a valid token comes from
+// an authentication event that is too old or not strong enough for the
+// request. A caller seeing it should answer 401 with an RFC 9470 challenge.
+var ErrInsufficientUserAuthentication = newKind("insufficient user authentication", nil)
+
+// Reasons for insufficient user authentication. Each of them also matches
+// ErrInsufficientUserAuthentication.
+var (
+	ErrAuthenticationTooOld = newKind("authentication too old", ErrInsufficientUserAuthentication)
+	ErrAcrNotAccepted       = newKind("authentication context class not accepted", ErrInsufficientUserAuthentication)
+	ErrAmrMissing           = newKind("required authentication method missing", ErrInsufficientUserAuthentication)
+)
+
+// Errors caused by the verifier's dependencies. Each of them also matches
+// ErrUnavailable.
+var (
+	ErrMetadataUnavailable    = newKind("metadata unavailable", ErrUnavailable)
+	ErrKeySetUnavailable      = newKind("key set unavailable", ErrUnavailable)
+	ErrIntrospectionFailed    = newKind("introspection failed", ErrUnavailable)
+	ErrRevocationUnavailable  = newKind("revocation status unavailable", ErrUnavailable)
+	ErrReplayStoreUnavailable = newKind("replay store unavailable", ErrUnavailable)
+)
+
+type kind struct {
+	message string
+	parent  error
+}
+
+func newKind(message string, parent error) error {
+	return &kind{message: message, parent: parent}
+}
+
+func (k *kind) Error() string {
+	return k.message
+}
+
+func (k *kind) Is(target error) bool {
+	return k.parent != nil && stderrors.Is(k.parent, target)
+}
+
+type marked struct {
+	err  error
+	kind error
+}
+
+// Mark returns an error with the same message as err that also matches kind
+// with errors.Is. err itself remains reachable through errors.Unwrap.
+func Mark(err error, kind error) error {
+	if err == nil {
+		return nil
+	}
+	return &marked{err: err, kind: kind}
+}
+
+func (m *marked) Error() string {
+	return m.err.Error()
+}
+
+func (m *marked) Unwrap() error {
+	return m.err
+}
+
+func (m *marked) Is(target error) bool {
+	return stderrors.Is(m.kind, target)
+}
@@ -0,0 +1,150 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+// Package interceptor protects gRPC servers with bearer access tokens.
+package interceptor
+
+import (
+	"context"
+	stderrors "errors"
+	"strconv"
+	"strings"
+
+	jwtverifier "github.com/hung12ct/okta-jwt-verifier-golang/v2"
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/middleware"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorDomain is the domain reported in the errdetails.ErrorInfo attached to
+// the status of rejected calls.
+const ErrorDomain = "okta-jwt-verifier-golang"
+
+// ErrMissingToken is returned when the call carries no bearer token in its
+// authorization metadata.
+var ErrMissingToken = stderrors.New("no bearer token present in authorization metadata")
+
+// UnaryServerInterceptor returns a grpc.UnaryServerInterceptor that rejects
+// calls without a valid access token. opts, such as
+// jwtverifier.WithRequiredScopes, are passed to every verification. The
+// verified token is available to handlers through JwtFromContext.
+func UnaryServerInterceptor(verifier middleware.Verifier, opts ...jwtverifier.VerifyOption) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, verifier, opts)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a grpc.StreamServerInterceptor that rejects
+// streams without a valid access token. opts are passed to every
+// verification. The verified token is available to handlers through
+// JwtFromContext on the stream's context.
+func StreamServerInterceptor(verifier middleware.Verifier, opts ...jwtverifier.VerifyOption) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), verifier, opts)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// JwtFromContext returns the verified token stored in ctx by the interceptors.
+func JwtFromContext(ctx context.Context) (*jwtverifier.Jwt, bool) {
+	return middleware.JwtFromContext(ctx)
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func authenticate(ctx context.Context, verifier middleware.Verifier, opts []jwtverifier.VerifyOption) (context.Context, error) {
+	token, err := bearerToken(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	jwt, err := verifier.VerifyAccessTokenContext(ctx, token, opts...)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return middleware.NewContext(ctx, jwt), nil
+}
+
+func bearerToken(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		scheme, token, found := strings.Cut(value, " ")
+		if found && strings.EqualFold(scheme, "Bearer") && strings.TrimSpace(token) != "" {
+			return strings.TrimSpace(token), nil
+		}
+	}
+	return "", ErrMissingToken
+}
+
+// toStatus converts a verification error into a gRPC status carrying an
+// errdetails.ErrorInfo whose Reason uses the RFC 6750 error codes.
+func toStatus(err error) error {
+	code, reason, message := codes.Unauthenticated, "invalid_token", "the access token is invalid"
+	var meta map[string]string
+	switch {
+	case stderrors.Is(err, ErrMissingToken):
+		reason, message = "missing_token", "an access token is required"
+	case stderrors.Is(err, errors.ErrInsufficientScope):
+		code, reason, message = codes.PermissionDenied, "insufficient_scope", "the access token does not grant the required scope"
+		var insufficient *errors.InsufficientScope
+		if stderrors.As(err, &insufficient) {
+			meta = map[string]string{"scope": strings.Join(insufficient.Missing, " ")}
+		}
+	case stderrors.Is(err, errors.ErrInsufficientUserAuthentication):
+		reason, message = "insufficient_user_authentication", "a different authentication level is required"
+		var insufficient *errors.InsufficientUserAuthentication
+		if stderrors.As(err, &insufficient) {
+			meta = map[string]string{}
+			if len(insufficient.AcrValues) > 0 {
+				meta["acr_values"] = strings.Join(insufficient.AcrValues, " ")
+			}
+			if insufficient.MaxAge > 0 {
+				meta["max_age"] = strconv.FormatInt(int64(insufficient.MaxAge.Seconds()), 10)
+			}
+		}
+	case stderrors.Is(err, errors.ErrUnavailable):
+		code, reason, message = codes.Unavailable, "temporarily_unavailable", "the access token could not be verified"
+	case stderrors.Is(err, errors.ErrTokenExpired):
+		message = "the access token expired"
+	}
+
+	st, detailErr := status.New(code, message).WithDetails(&errdetails.ErrorInfo{
+		Reason:   reason,
+		Domain:   ErrorDomain,
+		Metadata: meta,
+	})
+	if detailErr != nil {
+		return status.Error(code, message)
+	}
+	return st.Err()
+}
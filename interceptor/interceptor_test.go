@@ -0,0 +1,254 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package interceptor_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	jwtverifier "github.com/hung12ct/okta-jwt-verifier-golang/v2"
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/interceptor"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type fakeVerifier map[string]error
+
+func (f fakeVerifier) VerifyAccessTokenContext(ctx context.Context, jwt string, opts ...jwtverifier.VerifyOption) (*jwtverifier.Jwt, error) {
+	if err, ok := f[jwt]; ok && err != nil {
+		return nil, err
+	}
+	return &jwtverifier.Jwt{Claims: map[string]interface{}{"sub": jwt}}, nil
+}
+
+// subjectHealthServer reports SERVING only when the verified token is in the
+// handler's context, so the tests can observe the claims propagation.
+type subjectHealthServer struct {
+	healthpb.UnimplementedHealthServer
+	inner *health.Server
+}
+
+func (s *subjectHealthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if jwt, ok := interceptor.JwtFromContext(ctx); !ok || jwt.Claims["sub"] != "good" {
+		return nil, status.Error(codes.Internal, "no verified token in context")
+	}
+	return s.inner.Check(ctx, req)
+}
+
+func (s *subjectHealthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	if jwt, ok := interceptor.JwtFromContext(stream.Context()); !ok || jwt.Claims["sub"] != "good" {
+		return status.Error(codes.Internal, "no verified token in context")
+	}
+	return stream.Send(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING})
+}
+
+func newClient(t *testing.T) healthpb.HealthClient {
+	t.Helper()
+
+	verifier := fakeVerifier{
+		"bad":         errors.ErrInvalidSignature,
+		"scope":       errors.ErrInsufficientScope,
+		"unavailable": errors.ErrKeySetUnavailable,
+		"stale":       errors.ErrAuthenticationTooOld,
+	}
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(interceptor.UnaryServerInterceptor(verifier)),
+		grpc.StreamInterceptor(interceptor.StreamServerInterceptor(verifier)),
+	)
+	healthpb.RegisterHealthServer(server, &subjectHealthServer{inner: health.NewServer()})
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return healthpb.NewHealthClient(conn)
+}
+
+func withToken(token string) context.Context {
+	if token == "" {
+		return context.Background()
+	}
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func requireStatus(t *testing.T, err error, code codes.Code, reason string) {
+	t.Helper()
+	st, ok := status.FromError(err)
+	require.True(t, ok, "expected a status error, got %v", err)
+	require.Equal(t, code, st.Code())
+	if reason == "" {
+		return
+	}
+	require.Len(t, st.Details(), 1)
+	info, ok := st.Details()[0].(*errdetails.ErrorInfo)
+	require.True(t, ok)
+	require.Equal(t, reason, info.Reason)
+	require.Equal(t, interceptor.ErrorDomain, info.Domain)
+}
+
+var statusTests = []struct {
+	name   string
+	token  string
+	code   codes.Code
+	reason string
+}{
+	{"valid", "good", codes.OK, ""},
+	{"missing", "", codes.Unauthenticated, "missing_token"},
+	{"invalid", "bad", codes.Unauthenticated, "invalid_token"},
+	{"insufficient scope", "scope", codes.PermissionDenied, "insufficient_scope"},
+	{"unavailable", "unavailable", codes.Unavailable, "temporarily_unavailable"},
+	{"insufficient user authentication", "stale", codes.Unauthenticated, "insufficient_user_authentication"},
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	client := newClient(t)
+
+	for _, tt := range statusTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.Check(withToken(tt.token), &healthpb.HealthCheckRequest{})
+			requireStatus(t, err, tt.code, tt.reason)
+		})
+	}
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	client := newClient(t)
+
+	for _, tt := range statusTests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, err := client.Watch(withToken(tt.token), &healthpb.HealthCheckRequest{})
+			require.NoError(t, err)
+			_, err = stream.Recv()
+			requireStatus(t, err, tt.code, tt.reason)
+		})
+	}
+}
+
+// newSignedToken signs claims with key, whose public part is what the
+// verifier returned by newOfflineVerifier trusts.
+func newSignedToken(t *testing.T, key jwk.Key, claims map[string]interface{}) string {
+	t.Helper()
+
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed, err := jws.Sign(payload, jws.WithKey(jwa.RS256, key))
+	require.NoError(t, err)
+	return string(signed)
+}
+
+func newOfflineVerifier(t *testing.T, issuer string) (*jwtverifier.JwtVerifier, jwk.Key) {
+	t.Helper()
+
+	raw, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	key, err := jwk.FromRaw(raw)
+	require.NoError(t, err)
+	require.NoError(t, key.Set(jwk.KeyIDKey, "key"))
+	pub, err := jwk.PublicKeyOf(key)
+	require.NoError(t, err)
+	set := jwk.NewSet()
+	require.NoError(t, set.AddKey(pub))
+	jwks, err := json.Marshal(set)
+	require.NoError(t, err)
+
+	verifier, err := (&jwtverifier.JwtVerifier{
+		Issuer:     issuer,
+		KeySetFile: "keys.json",
+		FS:         fstest.MapFS{"keys.json": {Data: jwks}},
+	}).New()
+	require.NoError(t, err)
+	t.Cleanup(func() { verifier.Close() })
+	return verifier, key
+}
+
+func TestInterceptorsPassVerifyOptions(t *testing.T) {
+	issuer := "https://example.com/oauth2/default"
+	verifier, key := newOfflineVerifier(t, issuer)
+
+	listener := bufconn.Listen(1 << 20)
+	opts := []jwtverifier.VerifyOption{jwtverifier.WithRequiredScopes("admin"), jwtverifier.WithMaxAge(time.Hour)}
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(interceptor.UnaryServerInterceptor(verifier, opts...)),
+		grpc.StreamInterceptor(interceptor.StreamServerInterceptor(verifier, opts...)),
+	)
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	client := healthpb.NewHealthClient(conn)
+
+	now := time.Now()
+	token := func(scopes []string, authTime time.Time) string {
+		return newSignedToken(t, key, map[string]interface{}{
+			"iss":       issuer,
+			"sub":       "user@example.com",
+			"iat":       now.Unix(),
+			"exp":       now.Add(time.Hour).Unix(),
+			"scp":       scopes,
+			"auth_time": authTime.Unix(),
+		})
+	}
+
+	_, err = client.Check(withToken(token([]string{"admin"}, now)), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+
+	_, err = client.Check(withToken(token([]string{"read"}, now)), &healthpb.HealthCheckRequest{})
+	requireStatus(t, err, codes.PermissionDenied, "insufficient_scope")
+	info := status.Convert(err).Details()[0].(*errdetails.ErrorInfo)
+	require.Equal(t, "admin", info.Metadata["scope"])
+
+	stream, err := client.Watch(withToken(token([]string{"admin"}, now.Add(-2*time.Hour))), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	requireStatus(t, err, codes.Unauthenticated, "insufficient_user_authentication")
+	info = status.Convert(err).Details()[0].(*errdetails.ErrorInfo)
+	require.Equal(t, "3600", info.Metadata["max_age"])
+}
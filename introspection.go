@@ -0,0 +1,264 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package jwtverifier
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/discovery"
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/utils"
+	"github.com/patrickmn/go-cache"
+)
+
+// IntrospectionVerifier verifies access tokens by asking the authorization
+// server about them (RFC 7662) instead of checking their signature locally.
+// It works for opaque tokens and for tokens from the Okta org authorization
+// server, and returns the same *Jwt as JwtVerifier.
+type IntrospectionVerifier struct {
+	Issuer string
+
+	// ClientId and ClientSecret authenticate the verifier to the
+	// introspection endpoint. Without a ClientSecret the client id is sent in
+	// the request body, as for public clients.
+	ClientId     string
+	ClientSecret string
+
+	ClaimsToValidate map[string]string
+
+	Discovery discovery.Discovery
+
+	Client *http.Client
+
+	// Cache and ContextCache customize the cache used to store the metadata,
+	// as on JwtVerifier.
+	Cache        func(func(string) (interface{}, error), time.Duration, time.Duration) (utils.Cacher, error)
+	ContextCache func(utils.ContextLookup, time.Duration, time.Duration) (utils.ContextCacher, error)
+
+	// InactiveTimeout is how long an inactive result is remembered before
+	// the token is introspected again. It defaults to one minute.
+	InactiveTimeout time.Duration
+
+	Timeout time.Duration
+	Cleanup time.Duration
+
+	verifier *JwtVerifier
+	results  *cache.Cache
+}
+
+func (v *IntrospectionVerifier) New() (*IntrospectionVerifier, error) {
+	if v.ClientId == "" {
+		return nil, fmt.Errorf("a ClientId is required for introspection")
+	}
+
+	if v.InactiveTimeout == 0 {
+		v.InactiveTimeout = time.Minute
+	}
+
+	verifier, err := (&JwtVerifier{
+		Issuer:           v.Issuer,
+		ClaimsToValidate: v.ClaimsToValidate,
+		Discovery:        v.Discovery,
+		Client:           v.Client,
+		Cache:            v.Cache,
+		ContextCache:     v.ContextCache,
+		Timeout:          v.Timeout,
+		Cleanup:          v.Cleanup,
+	}).New()
+	if err != nil {
+		return nil, err
+	}
+	v.verifier = verifier
+	v.Discovery = verifier.Discovery
+	v.Client = verifier.Client
+	v.Timeout = verifier.Timeout
+	v.Cleanup = verifier.Cleanup
+
+	v.results = cache.New(v.Timeout, v.Cleanup)
+	return v, nil
+}
+
+// Close releases the resources held by the metadata cache.
+func (v *IntrospectionVerifier) Close() error {
+	return v.verifier.Close()
+}
+
+func (v *IntrospectionVerifier) SetLeeway(duration string) {
+	v.verifier.SetLeeway(duration)
+}
+
+func (v *IntrospectionVerifier) VerifyAccessToken(jwt string, opts ...VerifyOption) (*Jwt, error) {
+	return v.VerifyAccessTokenContext(context.Background(), jwt, opts...)
+}
+
+// VerifyAccessTokenContext introspects jwt, unless a result for it is
+// cached, and validates the returned claims like JwtVerifier does.
+func (v *IntrospectionVerifier) VerifyAccessTokenContext(ctx context.Context, jwt string, opts ...VerifyOption) (*Jwt, error) {
+	if jwt == "" {
+		return nil, fmt.Errorf("token is not valid: %w", errors.JwtEmptyStringError())
+	}
+
+	claims, err := v.introspect(ctx, jwt)
+	if err != nil {
+		return nil, err
+	}
+
+	if active, _ := claims["active"].(bool); !active {
+		return nil, errors.Mark(fmt.Errorf("the token is not active"), errors.ErrTokenInactive)
+	}
+
+	myJwt := Jwt{
+		Claims: claims,
+	}
+
+	err = v.verifier.validateIss(claims["iss"])
+	if err != nil {
+		return &myJwt, fmt.Errorf("the `Issuer` was not able to be validated. %w", err)
+	}
+
+	err = v.verifier.validateAudience(claims["aud"])
+	if err != nil {
+		return &myJwt, fmt.Errorf("the `Audience` was not able to be validated. %w", err)
+	}
+
+	err = v.verifier.validateClientId(claims["cid"])
+	if err != nil {
+		return &myJwt, fmt.Errorf("the `Client Id` was not able to be validated. %w", err)
+	}
+
+	// exp and iat are optional in an introspection response
+	if _, ok := claims["exp"]; ok {
+		err = v.verifier.validateExp(claims["exp"])
+		if err != nil {
+			return &myJwt, fmt.Errorf("the `Expiration` was not able to be validated. %w", err)
+		}
+	}
+
+	if _, ok := claims["iat"]; ok {
+		err = v.verifier.validateIat(claims["iat"])
+		if err != nil {
+			return &myJwt, fmt.Errorf("the `Issued At` was not able to be validated. %w", err)
+		}
+	}
+
+	err = newVerifyOptions(opts).validate(claims, "", v.verifier.leeway)
+	if err != nil {
+		return &myJwt, err
+	}
+
+	return &myJwt, nil
+}
+
+// introspect returns a copy of the introspection response for token. Active
+// results are cached until the token expires and inactive ones for
+// InactiveTimeout. Only a hash of the token is kept.
+func (v *IntrospectionVerifier) introspect(ctx context.Context, token string) (map[string]interface{}, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+
+	if cached, found := v.results.Get(key); found {
+		return copyClaims(cached.(map[string]interface{})), nil
+	}
+
+	claims, err := v.requestIntrospection(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	// Okta reports the client as client_id; expose it as cid so that the
+	// claims look the same as those of a locally verified token.
+	if _, ok := claims["cid"]; !ok {
+		if clientId, ok := claims["client_id"]; ok {
+			claims["cid"] = clientId
+		}
+	}
+
+	ttl := v.InactiveTimeout
+	if active, _ := claims["active"].(bool); active {
+		ttl = v.Timeout
+		if exp, ok := claims["exp"].(float64); ok {
+			ttl = time.Until(time.Unix(int64(exp), 0))
+		}
+	}
+	if ttl > 0 {
+		v.results.Set(key, claims, ttl)
+	}
+
+	return copyClaims(claims), nil
+}
+
+func (v *IntrospectionVerifier) requestIntrospection(ctx context.Context, token string) (map[string]interface{}, error) {
+	metaData, err := v.verifier.getMetaData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := metaData.IntrospectionEndpoint
+	if endpoint == "" {
+		return nil, errors.Mark(fmt.Errorf("failed to introspect token: missing 'introspection_endpoint' from metadata"), errors.ErrMetadataUnavailable)
+	}
+
+	form := url.Values{}
+	form.Set("token", token)
+	form.Set("token_type_hint", "access_token")
+	if v.ClientSecret == "" {
+		form.Set("client_id", v.ClientId)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, errors.Mark(fmt.Errorf("request for introspection was not successful: %w", err), errors.ErrIntrospectionFailed)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if v.ClientSecret != "" {
+		// RFC 6749 section 2.3.1 requires the credentials to be form encoded
+		req.SetBasicAuth(url.QueryEscape(v.ClientId), url.QueryEscape(v.ClientSecret))
+	}
+
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return nil, errors.Mark(fmt.Errorf("request for introspection was not successful: %w", err), errors.ErrIntrospectionFailed)
+	}
+	defer resp.Body.Close()
+
+	ok := resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !ok {
+		return nil, errors.Mark(fmt.Errorf("request for introspection %q was not HTTP 2xx OK, it was: %d", endpoint, resp.StatusCode), errors.ErrIntrospectionFailed)
+	}
+
+	claims := make(map[string]interface{})
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, errors.Mark(fmt.Errorf("could not decode introspection response from %q: %w", endpoint, err), errors.ErrIntrospectionFailed)
+	}
+	return claims, nil
+}
+
+func copyClaims(claims map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(claims))
+	for k, v := range claims {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,154 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package jwtverifier
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	oktaErrors "github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/require"
+)
+
+func mockIntrospection(t *testing.T, issuer string, responses map[string]map[string]interface{}) *int {
+	t.Helper()
+
+	httpmock.RegisterResponder("GET", issuer+"/.well-known/openid-configuration",
+		httpmock.NewJsonResponderOrPanic(200, map[string]interface{}{
+			"issuer":                 issuer,
+			"introspection_endpoint": issuer + "/v1/introspect",
+		}))
+
+	calls := 0
+	httpmock.RegisterResponder("POST", issuer+"/v1/introspect", func(req *http.Request) (*http.Response, error) {
+		calls++
+		// credentials are form encoded before being put in the header
+		id, secret, ok := req.BasicAuth()
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+		if !ok || id != "client-id" || secret != "s3cr3t+/" {
+			return httpmock.NewStringResponse(401, `{"error":"invalid_client"}`), nil
+		}
+		if err := req.ParseForm(); err != nil {
+			return nil, err
+		}
+		if req.PostForm.Get("token_type_hint") != "access_token" {
+			return httpmock.NewStringResponse(400, `{"error":"invalid_request"}`), nil
+		}
+		response, ok := responses[req.PostForm.Get("token")]
+		if !ok {
+			return httpmock.NewStringResponse(500, ""), nil
+		}
+		return httpmock.NewJsonResponse(200, response)
+	})
+	return &calls
+}
+
+func TestIntrospectionVerifier(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.okta.com"
+	now := time.Now().Unix()
+	calls := mockIntrospection(t, issuer, map[string]map[string]interface{}{
+		"opaque-token": {
+			"active":    true,
+			"iss":       issuer,
+			"aud":       "api://default",
+			"client_id": "client-id",
+			"sub":       "user@example.com",
+			"scope":     "openid profile",
+			"iat":       now,
+			"exp":       now + 3600,
+		},
+		"revoked-token": {"active": false},
+	})
+
+	iv := IntrospectionVerifier{
+		Issuer:       issuer,
+		ClientId:     "client-id",
+		ClientSecret: "s3cr3t+/",
+		ClaimsToValidate: map[string]string{
+			"aud": "api://default",
+			"cid": "client-id",
+		},
+	}
+	verifier, err := iv.New()
+	require.NoError(t, err)
+	defer verifier.Close()
+
+	jwt, err := verifier.VerifyAccessToken("opaque-token", WithRequiredScopes("profile"))
+	require.NoError(t, err)
+	require.Equal(t, "user@example.com", jwt.Claims["sub"])
+	require.Equal(t, "client-id", jwt.Claims["cid"])
+	require.Equal(t, 1, *calls)
+
+	// active results are cached, and callers cannot change the cached copy
+	jwt.Claims["sub"] = "someone else"
+	jwt, err = verifier.VerifyAccessToken("opaque-token")
+	require.NoError(t, err)
+	require.Equal(t, "user@example.com", jwt.Claims["sub"])
+	require.Equal(t, 1, *calls)
+
+	_, err = verifier.VerifyAccessToken("opaque-token", WithRequiredScopes("admin"))
+	require.ErrorIs(t, err, oktaErrors.ErrInsufficientScope)
+
+	// inactive results are cached too
+	_, err = verifier.VerifyAccessToken("revoked-token")
+	require.ErrorIs(t, err, oktaErrors.ErrTokenInactive)
+	require.ErrorIs(t, err, oktaErrors.ErrInvalidToken)
+	_, err = verifier.VerifyAccessToken("revoked-token")
+	require.ErrorIs(t, err, oktaErrors.ErrTokenInactive)
+	require.Equal(t, 2, *calls)
+
+	_, err = verifier.VerifyAccessToken("unknown-token")
+	require.ErrorIs(t, err, oktaErrors.ErrIntrospectionFailed)
+	require.ErrorIs(t, err, oktaErrors.ErrUnavailable)
+
+	_, err = verifier.VerifyAccessToken("")
+	require.ErrorIs(t, err, oktaErrors.ErrMalformedToken)
+}
+
+func TestIntrospectionVerifierValidatesClaims(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.okta.com"
+	now := time.Now().Unix()
+	mockIntrospection(t, issuer, map[string]map[string]interface{}{
+		"other-audience": {"active": true, "iss": issuer, "aud": "api://other", "exp": now + 3600},
+		"other-issuer":   {"active": true, "iss": "https://evil.example.com", "aud": "api://default", "exp": now + 3600},
+	})
+
+	verifier, err := (&IntrospectionVerifier{
+		Issuer:           issuer,
+		ClientId:         "client-id",
+		ClientSecret:     "s3cr3t+/",
+		ClaimsToValidate: map[string]string{"aud": "api://default"},
+	}).New()
+	require.NoError(t, err)
+	defer verifier.Close()
+
+	_, err = verifier.VerifyAccessToken("other-audience")
+	require.ErrorIs(t, err, oktaErrors.ErrAudienceMismatch)
+
+	_, err = verifier.VerifyAccessToken("other-issuer")
+	require.ErrorIs(t, err, oktaErrors.ErrIssuerMismatch)
+}
@@ -0,0 +1,116 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package jwtverifier
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwe"
+)
+
+var (
+	supportedKeyEncryptionAlgorithms = map[string]bool{
+		"RSA-OAEP":       true,
+		"RSA-OAEP-256":   true,
+		"ECDH-ES":        true,
+		"ECDH-ES+A128KW": true,
+		"ECDH-ES+A192KW": true,
+		"ECDH-ES+A256KW": true,
+	}
+
+	supportedContentEncryptionAlgorithms = map[string]bool{
+		"A128CBC-HS256": true,
+		"A192CBC-HS384": true,
+		"A256CBC-HS512": true,
+		"A128GCM":       true,
+		"A192GCM":       true,
+		"A256GCM":       true,
+	}
+)
+
+// isEncryptedJwt reports whether jwt is in the five part JWE compact
+// serialization.
+func isEncryptedJwt(jwt string) bool {
+	return strings.Count(jwt, ".") == 4
+}
+
+// decryptJwt decrypts a JWE compact token with the DecryptionKeys and returns
+// the nested JWS.
+func (j *JwtVerifier) decryptJwt(jwt string) (string, error) {
+	if len(j.DecryptionKeys) == 0 {
+		return "", errors.Mark(fmt.Errorf("the token is encrypted but no DecryptionKeys are configured"), errors.ErrDecryptionFailed)
+	}
+
+	// check the algorithms before handing the token to the JWE library
+	header, _, _ := strings.Cut(jwt, ".")
+	decoded, err := base64.RawURLEncoding.DecodeString(header)
+	if err != nil {
+		return "", errors.Mark(fmt.Errorf("the tokens header does not appear to be a base64 encoded string"), errors.ErrMalformedToken)
+	}
+	var jsonObject map[string]interface{}
+	if json.Unmarshal(decoded, &jsonObject) != nil {
+		return "", errors.Mark(fmt.Errorf("the tokens header is not a json object"), errors.ErrMalformedToken)
+	}
+	alg, _ := jsonObject["alg"].(string)
+	if !supportedKeyEncryptionAlgorithms[alg] {
+		return "", errors.Mark(fmt.Errorf("alg: %q is not a supported key encryption algorithm", alg), errors.ErrUnsupportedEncryption)
+	}
+	enc, _ := jsonObject["enc"].(string)
+	if !supportedContentEncryptionAlgorithms[enc] {
+		return "", errors.Mark(fmt.Errorf("enc: %q is not a supported content encryption algorithm", enc), errors.ErrUnsupportedEncryption)
+	}
+
+	rsaAlg := strings.HasPrefix(alg, "RSA-")
+	for _, key := range j.DecryptionKeys {
+		switch key.(type) {
+		case *rsa.PrivateKey:
+			if !rsaAlg {
+				continue
+			}
+		case *ecdsa.PrivateKey:
+			if rsaAlg {
+				continue
+			}
+		}
+		payload, err := jwe.Decrypt([]byte(jwt), jwe.WithKey(jwa.KeyEncryptionAlgorithm(alg), key))
+		if err == nil {
+			return string(payload), nil
+		}
+	}
+	return "", errors.Mark(fmt.Errorf("the token could not be decrypted with any of the DecryptionKeys"), errors.ErrDecryptionFailed)
+}
+
+// validateDecryptionKeys checks that every key in keys can be used with one
+// of the supported key encryption algorithms.
+func validateDecryptionKeys(keys []crypto.PrivateKey) error {
+	for i, key := range keys {
+		switch key.(type) {
+		case *rsa.PrivateKey, *ecdsa.PrivateKey:
+		default:
+			return fmt.Errorf("decryption key %d is a %T, only *rsa.PrivateKey and *ecdsa.PrivateKey are supported", i, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package jwtverifier
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"testing"
+
+	oktaErrors "github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+	"github.com/jarcoal/httpmock"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwe"
+	"github.com/stretchr/testify/require"
+)
+
+func encryptToken(t *testing.T, token string, alg jwa.KeyEncryptionAlgorithm, enc jwa.ContentEncryptionAlgorithm, key interface{}) string {
+	t.Helper()
+
+	headers := jwe.NewHeaders()
+	require.NoError(t, headers.Set("cty", "JWT"))
+	encrypted, err := jwe.Encrypt([]byte(token), jwe.WithKey(alg, key), jwe.WithContentEncryption(enc), jwe.WithProtectedHeaders(headers))
+	require.NoError(t, err)
+	return string(encrypted)
+}
+
+func TestEncryptedIdToken(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	key := newSigningKey(t, "key", jwa.RS256)
+	mockIssuer(t, issuer, key)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	verifier, err := (&JwtVerifier{
+		Issuer:           issuer,
+		ClaimsToValidate: map[string]string{"nonce": "nonce"},
+		DecryptionKeys:   []crypto.PrivateKey{rsaKey, ecKey},
+	}).New()
+	require.NoError(t, err)
+
+	claims := validClaims(issuer)
+	claims["nonce"] = "nonce"
+	idToken := signToken(t, key, jwa.RS256, nil, claims)
+
+	jwt, err := verifier.VerifyIdToken(encryptToken(t, idToken, jwa.RSA_OAEP_256, jwa.A256GCM, &rsaKey.PublicKey))
+	require.NoError(t, err)
+	require.Equal(t, "user@example.com", jwt.Claims["sub"])
+
+	_, err = verifier.VerifyIdToken(encryptToken(t, idToken, jwa.ECDH_ES, jwa.A128CBC_HS256, &ecKey.PublicKey))
+	require.NoError(t, err)
+
+	// plain ID tokens keep working
+	_, err = verifier.VerifyIdToken(idToken)
+	require.NoError(t, err)
+
+	_, err = verifier.VerifyIdToken(encryptToken(t, idToken, jwa.RSA_OAEP, jwa.A128GCM, &otherKey.PublicKey))
+	require.ErrorIs(t, err, oktaErrors.ErrDecryptionFailed)
+	require.ErrorIs(t, err, oktaErrors.ErrInvalidToken)
+
+	// the nested token is still verified
+	forged := signToken(t, newSigningKey(t, "key", jwa.RS256), jwa.RS256, nil, claims)
+	_, err = verifier.VerifyIdToken(encryptToken(t, forged, jwa.RSA_OAEP_256, jwa.A256GCM, &rsaKey.PublicKey))
+	require.ErrorIs(t, err, oktaErrors.ErrInvalidSignature)
+}
+
+func TestEncryptedIdTokenWithUnsupportedAlgorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	verifier, err := (&JwtVerifier{
+		Issuer:         "https://example.com/oauth2/default",
+		DecryptionKeys: []crypto.PrivateKey{rsaKey},
+	}).New()
+	require.NoError(t, err)
+
+	jweWithHeader := func(header string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(header)) + ".a.b.c.d"
+	}
+	for _, header := range []string{
+		`{"alg":"RSA-OAEP","enc":"A128CBC+HS256"}`,
+		`{"alg":"RSA-OAEP"}`,
+		`{"alg":"RSA1_5","enc":"A256GCM"}`,
+		`{"alg":"dir","enc":"A256GCM"}`,
+	} {
+		_, err = verifier.VerifyIdToken(jweWithHeader(header))
+		require.ErrorIs(t, err, oktaErrors.ErrUnsupportedEncryption, header)
+	}
+
+	noKeys, err := (&JwtVerifier{Issuer: "https://example.com/oauth2/default"}).New()
+	require.NoError(t, err)
+	_, err = noKeys.VerifyIdToken(jweWithHeader(`{"alg":"RSA-OAEP","enc":"A256GCM"}`))
+	require.ErrorIs(t, err, oktaErrors.ErrDecryptionFailed)
+}
+
+func TestDecryptionKeysAreValidatedByNew(t *testing.T) {
+	_, err := (&JwtVerifier{
+		Issuer:         "https://example.com/oauth2/default",
+		DecryptionKeys: []crypto.PrivateKey{[]byte("secret")},
+	}).New()
+	require.ErrorContains(t, err, "only *rsa.PrivateKey and *ecdsa.PrivateKey are supported")
+}
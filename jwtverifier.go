@@ -17,12 +17,17 @@
 package jwtverifier
 
 import (
+	"context"
+	"crypto"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/fs"
 	"net/http"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/hung12ct/okta-jwt-verifier-golang/v2/adaptors"
@@ -35,8 +40,29 @@ import (
 
 var (
 	regx = regexp.MustCompile(`[a-zA-Z0-9-_]+\.[a-zA-Z0-9-_]+\.?([a-zA-Z0-9-_]+)[/a-zA-Z0-9-_]+?$`)
+
+	supportedSigningAlgorithms = map[string]bool{
+		"RS256": true,
+		"RS384": true,
+		"RS512": true,
+		"PS256": true,
+		"PS384": true,
+		"PS512": true,
+		"ES256": true,
+		"ES384": true,
+		"ES512": true,
+		"EdDSA": true,
+	}
 )
 
+// defaultLeeway is the clock skew, in seconds, allowed unless SetLeeway is
+// called.
+const defaultLeeway = 120
+
+// defaultStaleGracePeriod is used with BackgroundRefresh when
+// StaleGracePeriod is not set.
+const defaultStaleGracePeriod = time.Hour
+
 type JwtVerifier struct {
 	Issuer string
 
@@ -51,8 +77,90 @@ type JwtVerifier struct {
 	// Cache allows customization of the cache used to store resources
 	Cache func(func(string) (interface{}, error), time.Duration, time.Duration) (utils.Cacher, error)
 
+	// ContextCache is used instead of Cache when set. Its lookups receive the
+	// context given to VerifyAccessTokenContext and VerifyIdTokenContext, so
+	// metadata and key set requests are cancelled along with the caller.
+	ContextCache func(utils.ContextLookup, time.Duration, time.Duration) (utils.ContextCacher, error)
+
+	// BackgroundRefresh renews the metadata and keys in the background
+	// before they expire, instead of fetching them on the first request
+	// after expiry. It is ignored when Cache or ContextCache is set. Call
+	// Close to stop the background goroutines.
+	BackgroundRefresh bool
+
+	// StaleGracePeriod is how long past their expiry the last good metadata
+	// and keys keep being used while background refreshes fail. It defaults
+	// to an hour; a negative value never uses them past their expiry.
+	StaleGracePeriod time.Duration
+
+	// SharedCache, when set, shares the fetched metadata and keys with every
+	// verifier using the same store, such as Redis, so that a fleet of
+	// processes does not fetch them once each. Entries are written under
+	// SharedCachePrefix, which defaults to "okta-jwt-verifier:". It is
+	// ignored when Cache or ContextCache is set, and takes precedence over
+	// BackgroundRefresh.
+	SharedCache       utils.KeyValueStore
+	SharedCachePrefix string
+
 	metadataCache utils.Cacher
 
+	// RevocationChecker, when set, is asked about every token that passed
+	// validation. Tokens it reports as revoked are rejected.
+	RevocationChecker RevocationChecker
+
+	// ReplayProtection accepts every token only once. Tokens must carry a
+	// jti, which is recorded in ReplayStore until the token expires.
+	ReplayProtection bool
+
+	// ReplayStore records the jti of accepted tokens when ReplayProtection
+	// is set, and of every accepted DPoP proof. It defaults to a
+	// MemoryReplayStore.
+	ReplayStore ReplayStore
+
+	// DPoPMaxAge is how old a DPoP proof may be, going by its iat. It
+	// defaults to five minutes.
+	DPoPMaxAge time.Duration
+
+	// DecryptionKeys decrypt ID tokens encrypted as JWE (RSA-OAEP or ECDH-ES)
+	// before their signature is verified. Each must be an *rsa.PrivateKey or
+	// an *ecdsa.PrivateKey. Encrypted tokens are rejected when it is empty.
+	DecryptionKeys []crypto.PrivateKey
+
+	// StrictAccessTokenProfile makes VerifyAccessToken enforce the JWT
+	// access token profile of RFC 9068: the typ header must be at+jwt and
+	// the iss, exp, aud, sub, client_id, iat and jti claims are required.
+	// This stops ID tokens from being accepted as access tokens.
+	// VerifyIdToken in turn rejects at+jwt tokens.
+	StrictAccessTokenProfile bool
+
+	// KeySetFile makes the verifier work offline: tokens are verified with
+	// the keys in this file, a JWKS document or a bundle of PEM encoded keys
+	// and x509 certificates, and no keys are ever fetched. It cannot be used
+	// with a custom Adaptor.
+	KeySetFile string
+
+	// MetadataFile holds the authorization server metadata as JSON. It is
+	// used instead of fetching the metadata from the issuer.
+	MetadataFile string
+
+	// FS is the file system KeySetFile and MetadataFile are read from, such
+	// as an embed.FS. It defaults to the operating system's file system.
+	FS fs.FS
+
+	// KeySetReloadInterval, when set, is how often KeySetFile is checked
+	// for changes. A changed file replaces the keys in use, so keys can be
+	// rotated without a restart. Call Close to stop watching the file.
+	KeySetReloadInterval time.Duration
+
+	// SigningAlgorithms is the allow-list of JWS algorithms a token may be
+	// signed with. It defaults to RS256 only. Symmetric algorithms are never
+	// accepted because the keys come from a public JWKS.
+	SigningAlgorithms []string
+
+	staticMetadata *ProviderMetadata
+	stopWatch      chan struct{}
+	closeOnce      sync.Once
+
 	leeway  int64
 	Timeout time.Duration
 	Cleanup time.Duration
@@ -63,25 +171,68 @@ type Jwt struct {
 }
 
 func (j *JwtVerifier) fetchMetaData(url string) (interface{}, error) {
-	resp, err := j.Client.Get(url)
+	return j.fetchMetaDataContext(context.Background(), url)
+}
+
+// fetchMetaDataContext fetches the metadata at url. When url is the first of
+// the locations an IssuerDiscovery returned, the other locations are tried
+// in turn if it fails, and the first metadata found is cached under url.
+func (j *JwtVerifier) fetchMetaDataContext(ctx context.Context, url string) (interface{}, error) {
+	urls := []string{url}
+	if disc, ok := j.Discovery.(discovery.IssuerDiscovery); ok {
+		if candidates := disc.GetMetadataUrls(j.Issuer); len(candidates) > 0 && candidates[0] == url {
+			urls = candidates
+		}
+	}
+
+	var metadata interface{}
+	var err error
+	for _, candidate := range urls {
+		metadata, err = j.requestMetaData(ctx, candidate)
+		if err == nil {
+			return metadata, nil
+		}
+	}
+	return nil, err
+}
+
+func (j *JwtVerifier) requestMetaData(ctx context.Context, url string) (interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("request for metadata was not successful: %w", err)
+		return nil, errors.Mark(fmt.Errorf("request for metadata was not successful: %w", err), errors.ErrMetadataUnavailable)
+	}
+	resp, err := j.Client.Do(req)
+	if err != nil {
+		return nil, errors.Mark(fmt.Errorf("request for metadata was not successful: %w", err), errors.ErrMetadataUnavailable)
 	}
 	defer resp.Body.Close()
 
 	ok := resp.StatusCode >= 200 && resp.StatusCode < 300
 	if !ok {
-		return nil, fmt.Errorf("request for metadata %q was not HTTP 2xx OK, it was: %d", url, resp.StatusCode)
+		return nil, errors.Mark(fmt.Errorf("request for metadata %q was not HTTP 2xx OK, it was: %d", url, resp.StatusCode), errors.ErrMetadataUnavailable)
 	}
 
-	metadata := make(map[string]interface{})
-	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Mark(fmt.Errorf("could not read metadata from %q: %w", url, err), errors.ErrMetadataUnavailable)
+	}
+	metadata, err := decodeProviderMetadata(data)
+	if err != nil {
+		return nil, errors.Mark(fmt.Errorf("could not decode metadata from %q: %w", url, err), errors.ErrMetadataUnavailable)
+	}
+	if err := j.validateMetadata(metadata); err != nil {
 		return nil, err
 	}
 	return metadata, nil
 }
 
 func (j *JwtVerifier) New() (*JwtVerifier, error) {
+	// the issuer of the metadata must match Issuer exactly, and
+	// authorization servers do not publish issuers ending in a slash
+	if strings.HasSuffix(j.Issuer, "/") {
+		return nil, fmt.Errorf("issuer %q must not end with a slash", j.Issuer)
+	}
+
 	// Default to OIDC discovery if none is defined
 	if j.Discovery == nil {
 		disc := oidc.Oidc{}
@@ -100,31 +251,112 @@ func (j *JwtVerifier) New() (*JwtVerifier, error) {
 		j.Client = http.DefaultClient
 	}
 
-	if j.Cache == nil {
-		j.Cache = utils.NewDefaultCache
+	// a shared cache needs a codec for its values, so the metadata and the
+	// key sets each get their own
+	keySetCache := j.ContextCache
+	if j.Cache == nil && j.ContextCache == nil {
+		j.ContextCache = utils.NewDefaultContextCache
+		if j.SharedCache != nil {
+			prefix := j.SharedCachePrefix
+			if prefix == "" {
+				prefix = "okta-jwt-verifier:"
+			}
+			j.ContextCache = utils.NewSharedContextCache(j.SharedCache, metadataCodec{verifier: j}, prefix+"metadata:")
+			keySetCache = utils.NewSharedContextCache(j.SharedCache, lestrratGoJwx.KeySetCodec{}, prefix+"jwks:")
+		} else if j.BackgroundRefresh {
+			grace := j.StaleGracePeriod
+			if grace == 0 {
+				grace = defaultStaleGracePeriod
+			}
+			j.ContextCache = func(lookup utils.ContextLookup, timeout, cleanup time.Duration) (utils.ContextCacher, error) {
+				return utils.NewRefreshingCache(lookup, timeout, cleanup, grace)
+			}
+		}
+		if keySetCache == nil {
+			keySetCache = j.ContextCache
+		}
+	}
+
+	if len(j.SigningAlgorithms) == 0 {
+		j.SigningAlgorithms = []string{"RS256"}
+	}
+	for _, alg := range j.SigningAlgorithms {
+		if !supportedSigningAlgorithms[alg] {
+			return nil, fmt.Errorf("signing algorithm %q is not supported", alg)
+		}
+	}
+
+	if err := validateDecryptionKeys(j.DecryptionKeys); err != nil {
+		return nil, err
+	}
+
+	keySet, keySetData, err := j.loadOfflineResources()
+	if err != nil {
+		return nil, err
 	}
 
 	// Default to LestrratGoJwx Adaptor if none is defined
 	if j.Adaptor == nil {
-		adaptor := &lestrratGoJwx.LestrratGoJwx{Cache: j.Cache, Timeout: j.Timeout, Cleanup: j.Cleanup, Client: j.Client}
+		adaptor := &lestrratGoJwx.LestrratGoJwx{
+			JWKSet:            keySet,
+			Cache:             j.Cache,
+			ContextCache:      keySetCache,
+			Timeout:           j.Timeout,
+			Cleanup:           j.Cleanup,
+			Client:            j.Client,
+			SigningAlgorithms: j.SigningAlgorithms,
+		}
 		adp, err := adaptor.New()
 		if err != nil {
 			return nil, err
 		}
 		j.Adaptor = adp
+
+		if keySet != nil && j.KeySetReloadInterval > 0 {
+			j.stopWatch = make(chan struct{})
+			go j.watchKeySet(adaptor, keySetData)
+		}
+	}
+
+	if j.ReplayStore == nil {
+		store, err := (&MemoryReplayStore{Cleanup: j.Cleanup}).New()
+		if err != nil {
+			return nil, err
+		}
+		j.ReplayStore = store
 	}
 
 	// Default to PT2M Leeway
-	j.leeway = 120
-	var err error
-	metadataCache, err := j.Cache(j.fetchMetaData, j.Timeout, j.Cleanup)
+	j.leeway = defaultLeeway
+	if j.ContextCache != nil {
+		j.metadataCache, err = j.ContextCache(j.fetchMetaDataContext, j.Timeout, j.Cleanup)
+	} else {
+		j.metadataCache, err = j.Cache(j.fetchMetaData, j.Timeout, j.Cleanup)
+	}
 	if err != nil {
 		return nil, err
 	}
-	j.metadataCache = metadataCache
 	return j, nil
 }
 
+// Close stops the background refreshes started when BackgroundRefresh is
+// set, and the watching of KeySetFile. The verifier must not be used
+// afterwards.
+func (j *JwtVerifier) Close() error {
+	if j.stopWatch != nil {
+		j.closeOnce.Do(func() { close(j.stopWatch) })
+	}
+	if closer, ok := j.metadataCache.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	if closer, ok := j.Adaptor.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
 func (j *JwtVerifier) SetLeeway(duration string) {
 	dur, _ := time.ParseDuration(duration)
 	j.leeway = int64(dur.Seconds())
@@ -138,13 +370,28 @@ func (j *JwtVerifier) SetCleanUp(duration time.Duration) {
 	j.Cleanup = duration
 }
 
-func (j *JwtVerifier) VerifyAccessToken(jwt string) (*Jwt, error) {
+func (j *JwtVerifier) VerifyAccessToken(jwt string, opts ...VerifyOption) (*Jwt, error) {
+	return j.VerifyAccessTokenContext(context.Background(), jwt, opts...)
+}
+
+// VerifyAccessTokenContext is like VerifyAccessToken but aborts fetching
+// metadata and keys once ctx is done.
+func (j *JwtVerifier) VerifyAccessTokenContext(ctx context.Context, jwt string, opts ...VerifyOption) (*Jwt, error) {
+	return j.verifyAccessToken(ctx, jwt, opts, nil)
+}
+
+// verifyAccessToken verifies an access token, calling bind, when it is not
+// nil, after every local check and before the RevocationChecker and
+// ReplayStore are consulted, so that a token failing bind is not recorded.
+func (j *JwtVerifier) verifyAccessToken(ctx context.Context, jwt string, opts []VerifyOption, bind func(*Jwt) error) (*Jwt, error) {
+	options := newVerifyOptions(opts)
+
 	validJwt, err := j.isValidJwt(jwt)
 	if !validJwt {
 		return nil, fmt.Errorf("token is not valid: %w", err)
 	}
 
-	resp, err := j.decodeJwt(jwt)
+	resp, err := j.decodeJwt(ctx, jwt)
 	if err != nil {
 		return nil, err
 	}
@@ -155,6 +402,11 @@ func (j *JwtVerifier) VerifyAccessToken(jwt string) (*Jwt, error) {
 		Claims: token,
 	}
 
+	err = j.validateAccessTokenProfile(jwt, token)
+	if err != nil {
+		return &myJwt, fmt.Errorf("the access token profile was not able to be validated. %w", err)
+	}
+
 	err = j.validateIss(token["iss"])
 	if err != nil {
 		return &myJwt, fmt.Errorf("the `Issuer` was not able to be validated. %w", err)
@@ -180,19 +432,58 @@ func (j *JwtVerifier) VerifyAccessToken(jwt string) (*Jwt, error) {
 		return &myJwt, fmt.Errorf("the `Issued At` was not able to be validated. %w", err)
 	}
 
+	err = j.validateNbf(token["nbf"])
+	if err != nil {
+		return &myJwt, fmt.Errorf("the `Not Before` was not able to be validated. %w", err)
+	}
+
+	err = options.validate(token, tokenAlgorithm(jwt), j.leeway)
+	if err != nil {
+		return &myJwt, err
+	}
+
+	if bind != nil {
+		err = bind(&myJwt)
+		if err != nil {
+			return &myJwt, err
+		}
+	}
+
+	// revocation may take a round trip to a shared store, so it comes after
+	// every local check
+	err = j.checkRevocation(ctx, token)
+	if err != nil {
+		return &myJwt, err
+	}
+
+	err = j.checkReplay(ctx, token)
+	if err != nil {
+		return &myJwt, fmt.Errorf("the `JWT ID` was not able to be validated. %w", err)
+	}
+
 	return &myJwt, nil
 }
 
-func (j *JwtVerifier) decodeJwt(jwt string) (interface{}, error) {
-	metaData, err := j.getMetaData()
-	if err != nil {
-		return nil, err
+func (j *JwtVerifier) decodeJwt(ctx context.Context, jwt string) (interface{}, error) {
+	// the adaptor ignores the jwks_uri when it was given the keys of KeySetFile
+	var jwksURI string
+	if j.KeySetFile == "" {
+		metaData, err := j.getMetaData(ctx)
+		if err != nil {
+			return nil, err
+		}
+		jwksURI = metaData.JwksUri
+		if jwksURI == "" {
+			return nil, errors.Mark(fmt.Errorf("failed to decode JWT: missing 'jwks_uri' from metadata"), errors.ErrMetadataUnavailable)
+		}
 	}
-	jwksURI, ok := metaData["jwks_uri"].(string)
-	if !ok {
-		return nil, fmt.Errorf("failed to decode JWT: missing 'jwks_uri' from metadata")
+	var resp interface{}
+	var err error
+	if adaptor, ok := j.Adaptor.(adaptors.ContextAdaptor); ok {
+		resp, err = adaptor.DecodeContext(ctx, jwt, jwksURI)
+	} else {
+		resp, err = j.Adaptor.Decode(jwt, jwksURI)
 	}
-	resp, err := j.Adaptor.Decode(jwt, jwksURI)
 	if err != nil {
 		return nil, fmt.Errorf("could not decode token: %w", err)
 	}
@@ -200,13 +491,30 @@ func (j *JwtVerifier) decodeJwt(jwt string) (interface{}, error) {
 	return resp, nil
 }
 
-func (j *JwtVerifier) VerifyIdToken(jwt string) (*Jwt, error) {
+func (j *JwtVerifier) VerifyIdToken(jwt string, opts ...VerifyOption) (*Jwt, error) {
+	return j.VerifyIdTokenContext(context.Background(), jwt, opts...)
+}
+
+// VerifyIdTokenContext is like VerifyIdToken but aborts fetching metadata
+// and keys once ctx is done. Encrypted ID tokens are decrypted with
+// DecryptionKeys first.
+func (j *JwtVerifier) VerifyIdTokenContext(ctx context.Context, jwt string, opts ...VerifyOption) (*Jwt, error) {
+	options := newVerifyOptions(opts)
+
+	if isEncryptedJwt(jwt) {
+		nested, err := j.decryptJwt(jwt)
+		if err != nil {
+			return nil, fmt.Errorf("token is not valid: %w", err)
+		}
+		jwt = nested
+	}
+
 	validJwt, err := j.isValidJwt(jwt)
 	if !validJwt {
 		return nil, fmt.Errorf("token is not valid: %w", err)
 	}
 
-	resp, err := j.decodeJwt(jwt)
+	resp, err := j.decodeJwt(ctx, jwt)
 	if err != nil {
 		return nil, err
 	}
@@ -217,6 +525,10 @@ func (j *JwtVerifier) VerifyIdToken(jwt string) (*Jwt, error) {
 		Claims: token,
 	}
 
+	if j.StrictAccessTokenProfile && isAccessTokenType(tokenHeader(jwt)["typ"]) {
+		return &myJwt, errors.Mark(fmt.Errorf("typ: an access token cannot be used as an ID token"), errors.ErrInvalidTokenType)
+	}
+
 	err = j.validateIss(token["iss"])
 	if err != nil {
 		return &myJwt, fmt.Errorf("the `Issuer` was not able to be validated. %w", err)
@@ -227,6 +539,11 @@ func (j *JwtVerifier) VerifyIdToken(jwt string) (*Jwt, error) {
 		return &myJwt, fmt.Errorf("the `Audience` was not able to be validated. %w", err)
 	}
 
+	err = j.validateAuthorizedParty(token["aud"], token["azp"])
+	if err != nil {
+		return &myJwt, fmt.Errorf("the `Authorized Party` was not able to be validated. %w", err)
+	}
+
 	err = j.validateExp(token["exp"])
 	if err != nil {
 		return &myJwt, fmt.Errorf("the `Expiration` was not able to be validated. %w", err)
@@ -237,11 +554,31 @@ func (j *JwtVerifier) VerifyIdToken(jwt string) (*Jwt, error) {
 		return &myJwt, fmt.Errorf("the `Issued At` was not able to be validated. %w", err)
 	}
 
+	err = j.validateNbf(token["nbf"])
+	if err != nil {
+		return &myJwt, fmt.Errorf("the `Not Before` was not able to be validated. %w", err)
+	}
+
 	err = j.validateNonce(token["nonce"])
 	if err != nil {
 		return &myJwt, fmt.Errorf("the `Nonce` was not able to be validated. %w", err)
 	}
 
+	err = options.validate(token, tokenAlgorithm(jwt), j.leeway)
+	if err != nil {
+		return &myJwt, err
+	}
+
+	err = j.checkRevocation(ctx, token)
+	if err != nil {
+		return &myJwt, err
+	}
+
+	err = j.checkReplay(ctx, token)
+	if err != nil {
+		return &myJwt, fmt.Errorf("the `JWT ID` was not able to be validated. %w", err)
+	}
+
 	return &myJwt, nil
 }
 
@@ -259,7 +596,7 @@ func (j *JwtVerifier) validateNonce(nonce interface{}) error {
 	}
 
 	if nonce != j.ClaimsToValidate["nonce"] {
-		return fmt.Errorf("nonce: %s does not match %s", nonce, j.ClaimsToValidate["nonce"])
+		return errors.Mark(fmt.Errorf("nonce: %s does not match %s", nonce, j.ClaimsToValidate["nonce"]), errors.ErrNonceMismatch)
 	}
 	return nil
 }
@@ -273,7 +610,7 @@ func (j *JwtVerifier) validateAudience(audience interface{}) error {
 	switch v := audience.(type) {
 	case string:
 		if v != j.ClaimsToValidate["aud"] {
-			return fmt.Errorf("aud: %s does not match %s", v, j.ClaimsToValidate["aud"])
+			return errors.Mark(fmt.Errorf("aud: %s does not match %s", v, j.ClaimsToValidate["aud"]), errors.ErrAudienceMismatch)
 		}
 	case []string:
 		for _, element := range v {
@@ -281,20 +618,20 @@ func (j *JwtVerifier) validateAudience(audience interface{}) error {
 				return nil
 			}
 		}
-		return fmt.Errorf("aud: %s does not match %s", v, j.ClaimsToValidate["aud"])
+		return errors.Mark(fmt.Errorf("aud: %s does not match %s", v, j.ClaimsToValidate["aud"]), errors.ErrAudienceMismatch)
 	case []interface{}:
 		for _, e := range v {
 			element, ok := e.(string)
 			if !ok {
-				return fmt.Errorf("unknown type for audience validation")
+				return errors.Mark(fmt.Errorf("unknown type for audience validation"), errors.ErrAudienceMismatch)
 			}
 			if element == j.ClaimsToValidate["aud"] {
 				return nil
 			}
 		}
-		return fmt.Errorf("aud: %s does not match %s", v, j.ClaimsToValidate["aud"])
+		return errors.Mark(fmt.Errorf("aud: %s does not match %s", v, j.ClaimsToValidate["aud"]), errors.ErrAudienceMismatch)
 	default:
-		return fmt.Errorf("unknown type for audience validation")
+		return errors.Mark(fmt.Errorf("unknown type for audience validation"), errors.ErrAudienceMismatch)
 	}
 
 	return nil
@@ -306,7 +643,7 @@ func (j *JwtVerifier) validateClientId(clientId interface{}) error {
 		switch v := clientId.(type) {
 		case string:
 			if v != cid {
-				return fmt.Errorf("cid: %s does not match %s", v, cid)
+				return errors.Mark(fmt.Errorf("cid: %s does not match %s", v, cid), errors.ErrClientIdMismatch)
 			}
 		case []string:
 			for _, element := range v {
@@ -314,9 +651,9 @@ func (j *JwtVerifier) validateClientId(clientId interface{}) error {
 					return nil
 				}
 			}
-			return fmt.Errorf("cid: %s does not match %s", v, cid)
+			return errors.Mark(fmt.Errorf("cid: %s does not match %s", v, cid), errors.ErrClientIdMismatch)
 		default:
-			return fmt.Errorf("unknown type for clientId validation")
+			return errors.Mark(fmt.Errorf("unknown type for clientId validation"), errors.ErrClientIdMismatch)
 		}
 	}
 	return nil
@@ -325,10 +662,10 @@ func (j *JwtVerifier) validateClientId(clientId interface{}) error {
 func (j *JwtVerifier) validateExp(exp interface{}) error {
 	expf, ok := exp.(float64)
 	if !ok {
-		return fmt.Errorf("exp: missing")
+		return errors.Mark(fmt.Errorf("exp: missing"), errors.ErrMissingClaim)
 	}
 	if float64(time.Now().Unix()-j.leeway) > expf {
-		return fmt.Errorf("the token is expired")
+		return errors.Mark(fmt.Errorf("the token is expired"), errors.ErrTokenExpired)
 	}
 	return nil
 }
@@ -336,10 +673,76 @@ func (j *JwtVerifier) validateExp(exp interface{}) error {
 func (j *JwtVerifier) validateIat(iat interface{}) error {
 	iatf, ok := iat.(float64)
 	if !ok {
-		return fmt.Errorf("iat: missing")
+		return errors.Mark(fmt.Errorf("iat: missing"), errors.ErrMissingClaim)
 	}
 	if float64(time.Now().Unix()+j.leeway) < iatf {
-		return fmt.Errorf("the token was issued in the future")
+		return errors.Mark(fmt.Errorf("the token was issued in the future"), errors.ErrIssuedInFuture)
+	}
+	return nil
+}
+
+// validateAccessTokenProfile enforces RFC 9068 sections 2.1 and 2.2 when
+// StrictAccessTokenProfile is set.
+func (j *JwtVerifier) validateAccessTokenProfile(jwt string, claims map[string]interface{}) error {
+	if !j.StrictAccessTokenProfile {
+		return nil
+	}
+	typ := tokenHeader(jwt)["typ"]
+	if !isAccessTokenType(typ) {
+		return errors.Mark(fmt.Errorf("typ: %v is not at+jwt", typ), errors.ErrInvalidTokenType)
+	}
+	for _, claim := range []string{"iss", "exp", "aud", "sub", "client_id", "iat", "jti"} {
+		if _, ok := claims[claim]; !ok {
+			return errors.Mark(fmt.Errorf("%s: missing", claim), errors.ErrMissingClaim)
+		}
+	}
+	return nil
+}
+
+// isAccessTokenType reports whether typ is the RFC 9068 media type, with or
+// without its application/ prefix.
+func isAccessTokenType(typ interface{}) bool {
+	s, _ := typ.(string)
+	s = strings.ToLower(s)
+	return s == "at+jwt" || s == "application/at+jwt"
+}
+
+func (j *JwtVerifier) validateNbf(nbf interface{}) error {
+	// nbf is optional, it is validated when the token carries it
+	if nbf == nil {
+		return nil
+	}
+	nbff, ok := nbf.(float64)
+	if !ok {
+		return errors.Mark(fmt.Errorf("nbf: is not a number"), errors.ErrMalformedToken)
+	}
+	if float64(time.Now().Unix()+j.leeway) < nbff {
+		return errors.Mark(fmt.Errorf("the token is not valid yet"), errors.ErrTokenNotYetValid)
+	}
+	return nil
+}
+
+// validateAuthorizedParty checks azp as OIDC Core section 3.1.3.7 describes:
+// an ID token with several audiences must name the client in azp, and azp,
+// when present, must be the client. The client is ClaimsToValidate["azp"],
+// or ClaimsToValidate["aud"] when that is not set.
+func (j *JwtVerifier) validateAuthorizedParty(audience interface{}, azp interface{}) error {
+	clientId, exists := j.ClaimsToValidate["azp"]
+	if !exists {
+		clientId, exists = j.ClaimsToValidate["aud"]
+	}
+	if !exists {
+		return nil
+	}
+
+	if azp == nil {
+		if audiences, ok := audience.([]interface{}); ok && len(audiences) > 1 {
+			return errors.Mark(fmt.Errorf("azp: missing for a token with %d audiences", len(audiences)), errors.ErrMissingClaim)
+		}
+		return nil
+	}
+	if azp != clientId {
+		return errors.Mark(fmt.Errorf("azp: %v does not match %s", azp, clientId), errors.ErrAuthorizedPartyMismatch)
 	}
 	return nil
 }
@@ -349,22 +752,31 @@ func (j *JwtVerifier) validateIss(issuer interface{}) error {
 	expectedIssuer := normalizeIssuer(j.Issuer)
 
 	if normalizedIssuer != expectedIssuer {
-		return fmt.Errorf("iss: %s does not match %s", normalizedIssuer, expectedIssuer)
+		return errors.Mark(fmt.Errorf("iss: %s does not match %s", normalizedIssuer, expectedIssuer), errors.ErrIssuerMismatch)
 	}
 	return nil
 }
 
-func (j *JwtVerifier) getMetaData() (map[string]interface{}, error) {
+func (j *JwtVerifier) getMetaData(ctx context.Context) (*ProviderMetadata, error) {
+	if j.staticMetadata != nil {
+		return j.staticMetadata, nil
+	}
+
 	metaDataUrl := j.Issuer + j.Discovery.GetWellKnownUrl()
+	if disc, ok := j.Discovery.(discovery.IssuerDiscovery); ok {
+		if urls := disc.GetMetadataUrls(j.Issuer); len(urls) > 0 {
+			metaDataUrl = urls[0]
+		}
+	}
 
-	value, err := j.metadataCache.Get(metaDataUrl)
+	value, err := utils.GetContext(ctx, j.metadataCache, metaDataUrl)
 	if err != nil {
 		return nil, err
 	}
 
-	metadata, ok := value.(map[string]interface{})
+	metadata, ok := value.(*ProviderMetadata)
 	if !ok {
-		return nil, fmt.Errorf("unable to cast %v to metadata", value)
+		return nil, errors.Mark(fmt.Errorf("unable to cast %v to metadata", value), errors.ErrMetadataUnavailable)
 	}
 	return metadata, nil
 }
@@ -377,7 +789,7 @@ func (j *JwtVerifier) isValidJwt(jwt string) (bool, error) {
 	// Verify that the JWT Follows correct JWT encoding.
 	jwtRegex := regx.MatchString
 	if !jwtRegex(jwt) {
-		return false, fmt.Errorf("token must contain at least 1 period ('.') and only characters 'a-Z 0-9 _'")
+		return false, errors.Mark(fmt.Errorf("token must contain at least 1 period ('.') and only characters 'a-Z 0-9 _'"), errors.ErrMalformedToken)
 	}
 
 	parts := strings.Split(jwt, ".")
@@ -385,33 +797,74 @@ func (j *JwtVerifier) isValidJwt(jwt string) (bool, error) {
 	header = padHeader(header)
 	headerDecoded, err := base64.StdEncoding.DecodeString(header)
 	if err != nil {
-		return false, fmt.Errorf("the tokens header does not appear to be a base64 encoded string")
+		return false, errors.Mark(fmt.Errorf("the tokens header does not appear to be a base64 encoded string"), errors.ErrMalformedToken)
 	}
 
 	var jsonObject map[string]interface{}
 	isHeaderJson := json.Unmarshal([]byte(headerDecoded), &jsonObject) == nil
 	if !isHeaderJson {
-		return false, fmt.Errorf("the tokens header is not a json object")
+		return false, errors.Mark(fmt.Errorf("the tokens header is not a json object"), errors.ErrMalformedToken)
 	}
 
 	_, algExists := jsonObject["alg"]
 	_, kidExists := jsonObject["kid"]
 
 	if !algExists {
-		return false, fmt.Errorf("the tokens header must contain an 'alg'")
+		return false, errors.Mark(fmt.Errorf("the tokens header must contain an 'alg'"), errors.ErrMalformedToken)
 	}
 
 	if !kidExists {
-		return false, fmt.Errorf("the tokens header must contain a 'kid'")
+		return false, errors.Mark(fmt.Errorf("the tokens header must contain a 'kid'"), errors.ErrMalformedToken)
 	}
 
-	if jsonObject["alg"] != "RS256" {
-		return false, fmt.Errorf("the only supported alg is RS256")
+	alg, _ := jsonObject["alg"].(string)
+	if !j.allowsAlgorithm(alg) {
+		if len(j.signingAlgorithms()) == 1 {
+			return false, errors.Mark(fmt.Errorf("the only supported alg is %s", j.signingAlgorithms()[0]), errors.ErrUnsupportedAlg)
+		}
+		return false, errors.Mark(fmt.Errorf("the supported algs are %s", strings.Join(j.signingAlgorithms(), ", ")), errors.ErrUnsupportedAlg)
 	}
 
 	return true, nil
 }
 
+func (j *JwtVerifier) signingAlgorithms() []string {
+	if len(j.SigningAlgorithms) == 0 {
+		return []string{"RS256"}
+	}
+	return j.SigningAlgorithms
+}
+
+func (j *JwtVerifier) allowsAlgorithm(alg string) bool {
+	for _, allowed := range j.signingAlgorithms() {
+		if allowed == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenHeader returns the decoded header of jwt, which isValidJwt has
+// already checked.
+func tokenHeader(jwt string) map[string]interface{} {
+	header, _, _ := strings.Cut(jwt, ".")
+	decoded, err := base64.StdEncoding.DecodeString(padHeader(header))
+	if err != nil {
+		return nil
+	}
+	var jsonObject map[string]interface{}
+	if json.Unmarshal(decoded, &jsonObject) != nil {
+		return nil
+	}
+	return jsonObject
+}
+
+// tokenAlgorithm returns the alg header of jwt.
+func tokenAlgorithm(jwt string) string {
+	alg, _ := tokenHeader(jwt)["alg"].(string)
+	return alg
+}
+
 func padHeader(header string) string {
 	if i := len(header) % 4; i != 0 {
 		header += strings.Repeat("=", 4-i)
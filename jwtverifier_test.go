@@ -18,7 +18,14 @@ package jwtverifier
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -31,9 +38,14 @@ import (
 	"time"
 
 	"github.com/hung12ct/okta-jwt-verifier-golang/v2/adaptors/lestrratGoJwx"
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/discovery/oauth2"
 	"github.com/hung12ct/okta-jwt-verifier-golang/v2/discovery/oidc"
+	oktaErrors "github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
 	"github.com/hung12ct/okta-jwt-verifier-golang/v2/utils"
 	"github.com/jarcoal/httpmock"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
 	"github.com/stretchr/testify/require"
 )
 
@@ -167,6 +179,32 @@ func Test_can_validate_exp(t *testing.T) {
 	}
 }
 
+func Test_can_validate_nbf(t *testing.T) {
+	jvs := JwtVerifier{
+		Issuer: "https://golang.oktapreview.com",
+	}
+
+	jv, _ := jvs.New()
+
+	// token not valid yet triggers error
+	err := jv.validateNbf(float64(time.Now().Unix() + 300))
+	if err == nil {
+		t.Errorf("the nbf validation did not trigger an error for a token that is not valid yet")
+	}
+
+	// token within leeway does not trigger error
+	err = jv.validateNbf(float64(time.Now().Unix() + 60))
+	if err != nil {
+		t.Errorf("the nbf validation triggered an error for valid token")
+	}
+
+	// nbf is optional
+	err = jv.validateNbf(nil)
+	if err != nil {
+		t.Errorf("the nbf validation triggered an error for a token without nbf")
+	}
+}
+
 // ID TOKEN TESTS
 func Test_invalid_formatting_of_id_token_throws_an_error(t *testing.T) {
 	jvs := JwtVerifier{
@@ -607,3 +645,606 @@ func TestRaceCondition(t *testing.T) {
 	validate(verifier, accessToken)
 	time.Sleep(2 * time.Second)
 }
+
+func newSigningKey(t *testing.T, kid string, alg jwa.SignatureAlgorithm) jwk.Key {
+	t.Helper()
+
+	var raw interface{}
+	var err error
+	switch alg {
+	case jwa.RS256, jwa.RS384, jwa.RS512, jwa.PS256, jwa.PS384, jwa.PS512:
+		raw, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jwa.ES256:
+		raw, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jwa.ES384:
+		raw, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case jwa.EdDSA:
+		_, raw, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		t.Fatalf("unsupported alg %s", alg)
+	}
+	require.NoError(t, err)
+
+	key, err := jwk.FromRaw(raw)
+	require.NoError(t, err)
+	require.NoError(t, key.Set(jwk.KeyIDKey, kid))
+	return key
+}
+
+func signToken(t *testing.T, key jwk.Key, alg jwa.SignatureAlgorithm, headers map[string]interface{}, claims map[string]interface{}) string {
+	t.Helper()
+
+	protected := jws.NewHeaders()
+	require.NoError(t, protected.Set(jws.KeyIDKey, key.KeyID()))
+	for k, v := range headers {
+		require.NoError(t, protected.Set(k, v))
+	}
+
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed, err := jws.Sign(payload, jws.WithKey(alg, key, jws.WithProtectedHeaders(protected)))
+	require.NoError(t, err)
+	return string(signed)
+}
+
+// mockIssuer registers the discovery document and JWKS for issuer with
+// httpmock. The caller is responsible for activating httpmock.
+func mockIssuer(t *testing.T, issuer string, keys ...jwk.Key) {
+	t.Helper()
+
+	set := jwk.NewSet()
+	for _, key := range keys {
+		pub, err := jwk.PublicKeyOf(key)
+		require.NoError(t, err)
+		require.NoError(t, set.AddKey(pub))
+	}
+	jwks, err := json.Marshal(set)
+	require.NoError(t, err)
+
+	httpmock.RegisterResponder("GET", issuer+"/.well-known/openid-configuration",
+		httpmock.NewJsonResponderOrPanic(200, map[string]interface{}{
+			"issuer":   issuer,
+			"jwks_uri": issuer + "/v1/keys",
+		}))
+	httpmock.RegisterResponder("GET", issuer+"/v1/keys", httpmock.NewBytesResponder(200, jwks))
+}
+
+func validClaims(issuer string) map[string]interface{} {
+	now := time.Now().Unix()
+	return map[string]interface{}{
+		"iss": issuer,
+		"sub": "user@example.com",
+		"aud": "api://default",
+		"cid": "client-id",
+		"iat": now,
+		"exp": now + 3600,
+	}
+}
+
+func TestSigningAlgorithmAllowList(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	rsaKey := newSigningKey(t, "rsa", jwa.RS256)
+	ecKey := newSigningKey(t, "ec", jwa.ES256)
+	edKey := newSigningKey(t, "ed", jwa.EdDSA)
+	mockIssuer(t, issuer, rsaKey, ecKey, edKey)
+
+	esToken := signToken(t, ecKey, jwa.ES256, nil, validClaims(issuer))
+
+	jvs := JwtVerifier{Issuer: issuer}
+	jv, err := jvs.New()
+	require.NoError(t, err)
+	_, err = jv.VerifyAccessToken(esToken)
+	require.ErrorContains(t, err, "the only supported alg is RS256")
+
+	jvs = JwtVerifier{Issuer: issuer, SigningAlgorithms: []string{"RS256", "ES256", "EdDSA"}}
+	jv, err = jvs.New()
+	require.NoError(t, err)
+
+	_, err = jv.VerifyAccessToken(esToken)
+	require.NoError(t, err)
+
+	_, err = jv.VerifyAccessToken(signToken(t, rsaKey, jwa.RS256, nil, validClaims(issuer)))
+	require.NoError(t, err)
+
+	_, err = jv.VerifyAccessToken(signToken(t, edKey, jwa.EdDSA, nil, validClaims(issuer)))
+	require.NoError(t, err)
+
+	_, err = jv.VerifyAccessToken(signToken(t, rsaKey, jwa.PS256, nil, validClaims(issuer)))
+	require.ErrorContains(t, err, "the supported algs are RS256, ES256, EdDSA")
+}
+
+func TestSigningAlgorithmMustMatchKeyType(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	rsaKey := newSigningKey(t, "rsa", jwa.RS256)
+	mockIssuer(t, issuer, rsaKey)
+
+	// An ES256 signature that claims to have been made with the RSA key.
+	ecKey := newSigningKey(t, "rsa", jwa.ES256)
+	token := signToken(t, ecKey, jwa.ES256, nil, validClaims(issuer))
+
+	jvs := JwtVerifier{Issuer: issuer, SigningAlgorithms: []string{"RS256", "ES256"}}
+	jv, err := jvs.New()
+	require.NoError(t, err)
+
+	_, err = jv.VerifyAccessToken(token)
+	require.ErrorContains(t, err, "cannot be used with alg ES256")
+}
+
+func TestUnsupportedSigningAlgorithmIsRejectedByNew(t *testing.T) {
+	jvs := JwtVerifier{Issuer: "https://example.com", SigningAlgorithms: []string{"HS256"}}
+	_, err := jvs.New()
+	require.ErrorContains(t, err, `signing algorithm "HS256" is not supported`)
+}
+
+func TestVerifyAccessTokenContextHonoursDeadline(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	key := newSigningKey(t, "rsa", jwa.RS256)
+	httpmock.RegisterResponder("GET", issuer+"/.well-known/openid-configuration",
+		func(req *http.Request) (*http.Response, error) {
+			time.Sleep(5 * time.Second)
+			return httpmock.NewStringResponse(200, "{}"), nil
+		})
+
+	jvs := JwtVerifier{Issuer: issuer}
+	jv, err := jvs.New()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = jv.VerifyAccessTokenContext(ctx, signToken(t, key, jwa.RS256, nil, validClaims(issuer)))
+	require.True(t, errors.Is(err, context.DeadlineExceeded), "expected deadline exceeded, got %v", err)
+	require.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestErrorsCanBeClassified(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	key := newSigningKey(t, "rsa", jwa.RS256)
+	unknownKey := newSigningKey(t, "unknown", jwa.RS256)
+	mockIssuer(t, issuer, key)
+
+	jvs := JwtVerifier{Issuer: issuer, ClaimsToValidate: map[string]string{"aud": "api://default"}}
+	jv, err := jvs.New()
+	require.NoError(t, err)
+
+	expired := validClaims(issuer)
+	expired["exp"] = time.Now().Unix() - 3600
+	wrongAudience := validClaims(issuer)
+	wrongAudience["aud"] = "api://other"
+	wrongIssuer := validClaims("https://evil.example.com")
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"empty", "", oktaErrors.ErrMalformedToken},
+		{"malformed", "aa.aa.aa", oktaErrors.ErrMalformedToken},
+		{"unsupported alg", "ew0KICAia2lkIjogImFiYzEyMyIsDQogICJhbGciOiAiSFMyNTYiDQp9.aa.aa", oktaErrors.ErrUnsupportedAlg},
+		{"unknown kid", signToken(t, unknownKey, jwa.RS256, nil, validClaims(issuer)), oktaErrors.ErrKeyNotFound},
+		{"expired", signToken(t, key, jwa.RS256, nil, expired), oktaErrors.ErrTokenExpired},
+		{"wrong audience", signToken(t, key, jwa.RS256, nil, wrongAudience), oktaErrors.ErrAudienceMismatch},
+		{"wrong issuer", signToken(t, key, jwa.RS256, nil, wrongIssuer), oktaErrors.ErrIssuerMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := jv.VerifyAccessToken(tt.token)
+			require.ErrorIs(t, err, tt.want)
+			require.ErrorIs(t, err, oktaErrors.ErrInvalidToken)
+			require.False(t, errors.Is(err, oktaErrors.ErrUnavailable))
+		})
+	}
+}
+
+func TestMetadataFailureIsUnavailable(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	key := newSigningKey(t, "rsa", jwa.RS256)
+	httpmock.RegisterResponder("GET", issuer+"/.well-known/openid-configuration", httpmock.NewStringResponder(503, ""))
+
+	jvs := JwtVerifier{Issuer: issuer}
+	jv, err := jvs.New()
+	require.NoError(t, err)
+
+	_, err = jv.VerifyAccessToken(signToken(t, key, jwa.RS256, nil, validClaims(issuer)))
+	require.ErrorIs(t, err, oktaErrors.ErrMetadataUnavailable)
+	require.ErrorIs(t, err, oktaErrors.ErrUnavailable)
+	require.False(t, errors.Is(err, oktaErrors.ErrInvalidToken))
+}
+
+func TestUnknownKidTriggersRateLimitedRefresh(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	oldKey := newSigningKey(t, "old", jwa.RS256)
+	newKey := newSigningKey(t, "new", jwa.RS256)
+	mockIssuer(t, issuer, oldKey)
+
+	published := []jwk.Key{oldKey}
+	fetches := 0
+	httpmock.RegisterResponder("GET", issuer+"/v1/keys", func(req *http.Request) (*http.Response, error) {
+		fetches++
+		set := jwk.NewSet()
+		for _, key := range published {
+			pub, err := jwk.PublicKeyOf(key)
+			require.NoError(t, err)
+			require.NoError(t, set.AddKey(pub))
+		}
+		return httpmock.NewJsonResponse(200, set)
+	})
+
+	jvs := JwtVerifier{Issuer: issuer}
+	jv, err := jvs.New()
+	require.NoError(t, err)
+
+	_, err = jv.VerifyAccessToken(signToken(t, oldKey, jwa.RS256, nil, validClaims(issuer)))
+	require.NoError(t, err)
+
+	// rotate the keys, the cached set does not know about the new kid yet
+	published = append(published, newKey)
+	_, err = jv.VerifyAccessToken(signToken(t, newKey, jwa.RS256, nil, validClaims(issuer)))
+	require.NoError(t, err)
+	require.Equal(t, 2, fetches)
+
+	// kids that do not exist do not cause another fetch within the interval
+	for _, kid := range []string{"made-up-1", "made-up-2", "made-up-3"} {
+		_, err = jv.VerifyAccessToken(signToken(t, newSigningKey(t, kid, jwa.RS256), jwa.RS256, nil, validClaims(issuer)))
+		require.ErrorIs(t, err, oktaErrors.ErrKeyNotFound)
+	}
+	require.Equal(t, 2, fetches)
+}
+
+func TestUnknownKidIsNegativelyCached(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	key := newSigningKey(t, "rsa", jwa.RS256)
+	mockIssuer(t, issuer, key)
+
+	adaptor := &lestrratGoJwx.LestrratGoJwx{Client: http.DefaultClient, MinRefreshInterval: time.Nanosecond}
+	adp, err := adaptor.New()
+	require.NoError(t, err)
+	jvs := JwtVerifier{Issuer: issuer, Adaptor: adp}
+	jv, err := jvs.New()
+	require.NoError(t, err)
+
+	token := signToken(t, newSigningKey(t, "unknown", jwa.RS256), jwa.RS256, nil, validClaims(issuer))
+	for i := 0; i < 3; i++ {
+		_, err = jv.VerifyAccessToken(token)
+		require.ErrorIs(t, err, oktaErrors.ErrKeyNotFound)
+	}
+	// the initial fetch plus a single forced refresh
+	require.Equal(t, 2, httpmock.GetCallCountInfo()["GET "+issuer+"/v1/keys"])
+}
+
+func TestUnknownKidRefreshHonoursDeadline(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	key := newSigningKey(t, "rsa", jwa.RS256)
+	mockIssuer(t, issuer, key)
+
+	set := jwk.NewSet()
+	pub, err := jwk.PublicKeyOf(key)
+	require.NoError(t, err)
+	require.NoError(t, set.AddKey(pub))
+	refreshing := make(chan struct{}, 1)
+	release := make(chan struct{})
+	fetched := false
+	httpmock.RegisterResponder("GET", issuer+"/v1/keys", func(req *http.Request) (*http.Response, error) {
+		if fetched {
+			// the forced refresh is slow
+			refreshing <- struct{}{}
+			<-release
+		}
+		fetched = true
+		return httpmock.NewJsonResponse(200, set)
+	})
+
+	jv, err := (&JwtVerifier{Issuer: issuer}).New()
+	require.NoError(t, err)
+	_, err = jv.VerifyAccessToken(signToken(t, key, jwa.RS256, nil, validClaims(issuer)))
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = jv.VerifyAccessToken(signToken(t, newSigningKey(t, "first", jwa.RS256), jwa.RS256, nil, validClaims(issuer)))
+	}()
+	<-refreshing
+	defer func() {
+		close(release)
+		<-done
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = jv.VerifyAccessTokenContext(ctx, signToken(t, newSigningKey(t, "second", jwa.RS256), jwa.RS256, nil, validClaims(issuer)))
+	require.True(t, errors.Is(err, context.DeadlineExceeded), "expected deadline exceeded, got %v", err)
+	require.ErrorIs(t, err, oktaErrors.ErrKeySetUnavailable)
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestBackgroundRefreshServesStaleValuesByDefault(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	key := newSigningKey(t, "rsa", jwa.RS256)
+	mockIssuer(t, issuer, key)
+
+	jv, err := (&JwtVerifier{Issuer: issuer, BackgroundRefresh: true, Timeout: 100 * time.Millisecond}).New()
+	require.NoError(t, err)
+	defer jv.Close()
+
+	token := signToken(t, key, jwa.RS256, nil, validClaims(issuer))
+	_, err = jv.VerifyAccessToken(token)
+	require.NoError(t, err)
+
+	// the authorization server goes down for longer than the cache timeout
+	httpmock.RegisterResponder("GET", issuer+"/.well-known/openid-configuration", httpmock.NewStringResponder(503, ""))
+	httpmock.RegisterResponder("GET", issuer+"/v1/keys", httpmock.NewStringResponder(503, ""))
+	time.Sleep(300 * time.Millisecond)
+
+	_, err = jv.VerifyAccessToken(token)
+	require.NoError(t, err)
+}
+
+func TestNotBeforeIsValidated(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	key := newSigningKey(t, "key", jwa.RS256)
+	mockIssuer(t, issuer, key)
+
+	verifier, err := (&JwtVerifier{Issuer: issuer}).New()
+	require.NoError(t, err)
+
+	claims := validClaims(issuer)
+	claims["nbf"] = time.Now().Add(time.Hour).Unix()
+	_, err = verifier.VerifyAccessToken(signToken(t, key, jwa.RS256, nil, claims))
+	require.ErrorIs(t, err, oktaErrors.ErrTokenNotYetValid)
+	require.ErrorIs(t, err, oktaErrors.ErrInvalidToken)
+
+	claims["nbf"] = time.Now().Unix()
+	_, err = verifier.VerifyAccessToken(signToken(t, key, jwa.RS256, nil, claims))
+	require.NoError(t, err)
+}
+
+func TestAuthorizedPartyIsValidatedForIdTokens(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	key := newSigningKey(t, "key", jwa.RS256)
+	mockIssuer(t, issuer, key)
+
+	verifier, err := (&JwtVerifier{
+		Issuer:           issuer,
+		ClaimsToValidate: map[string]string{"aud": "client-id", "nonce": "nonce"},
+	}).New()
+	require.NoError(t, err)
+
+	idToken := func(aud interface{}, azp interface{}) string {
+		claims := validClaims(issuer)
+		claims["aud"] = aud
+		claims["nonce"] = "nonce"
+		if azp != nil {
+			claims["azp"] = azp
+		}
+		return signToken(t, key, jwa.RS256, nil, claims)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"single audience without azp", idToken("client-id", nil), nil},
+		{"single audience with azp", idToken("client-id", "client-id"), nil},
+		{"several audiences with azp", idToken([]string{"client-id", "api://default"}, "client-id"), nil},
+		{"several audiences without azp", idToken([]string{"client-id", "api://default"}, nil), oktaErrors.ErrMissingClaim},
+		{"azp of another client", idToken([]string{"client-id", "api://default"}, "other-client"), oktaErrors.ErrAuthorizedPartyMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifier.VerifyIdToken(tt.token)
+			if tt.err == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestStrictAccessTokenProfile(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	key := newSigningKey(t, "key", jwa.RS256)
+	mockIssuer(t, issuer, key)
+
+	verifier, err := (&JwtVerifier{
+		Issuer:                   issuer,
+		ClaimsToValidate:         map[string]string{"nonce": "nonce"},
+		StrictAccessTokenProfile: true,
+	}).New()
+	require.NoError(t, err)
+
+	accessClaims := func() map[string]interface{} {
+		claims := validClaims(issuer)
+		claims["client_id"] = "client-id"
+		claims["jti"] = "AT.id"
+		return claims
+	}
+	atJwt := map[string]interface{}{"typ": "at+jwt"}
+
+	_, err = verifier.VerifyAccessToken(signToken(t, key, jwa.RS256, atJwt, accessClaims()))
+	require.NoError(t, err)
+	_, err = verifier.VerifyAccessToken(signToken(t, key, jwa.RS256, map[string]interface{}{"typ": "application/AT+JWT"}, accessClaims()))
+	require.NoError(t, err)
+
+	for _, claim := range []string{"iss", "exp", "aud", "sub", "client_id", "iat", "jti"} {
+		claims := accessClaims()
+		delete(claims, claim)
+		_, err = verifier.VerifyAccessToken(signToken(t, key, jwa.RS256, atJwt, claims))
+		require.ErrorIs(t, err, oktaErrors.ErrMissingClaim, claim)
+	}
+
+	// an ID token of the same issuer is not an access token
+	idClaims := validClaims(issuer)
+	idClaims["nonce"] = "nonce"
+	idToken := signToken(t, key, jwa.RS256, map[string]interface{}{"typ": "JWT"}, idClaims)
+	_, err = verifier.VerifyIdToken(idToken)
+	require.NoError(t, err)
+	_, err = verifier.VerifyAccessToken(idToken)
+	require.ErrorIs(t, err, oktaErrors.ErrInvalidTokenType)
+	require.ErrorIs(t, err, oktaErrors.ErrInvalidToken)
+
+	// and the other way around
+	accessIdClaims := accessClaims()
+	accessIdClaims["nonce"] = "nonce"
+	_, err = verifier.VerifyIdToken(signToken(t, key, jwa.RS256, atJwt, accessIdClaims))
+	require.ErrorIs(t, err, oktaErrors.ErrInvalidTokenType)
+}
+
+func TestOAuth2DiscoveryFallsBackBetweenWellKnownForms(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	key := newSigningKey(t, "key", jwa.RS256)
+	mockIssuer(t, issuer, key)
+
+	// Okta serves the metadata appended to the issuer only
+	httpmock.RegisterResponder("GET", "https://example.com/.well-known/oauth-authorization-server/oauth2/default",
+		httpmock.NewStringResponder(404, ""))
+	httpmock.RegisterResponder("GET", issuer+"/.well-known/oauth-authorization-server",
+		httpmock.NewJsonResponderOrPanic(200, map[string]interface{}{
+			"issuer":   issuer,
+			"jwks_uri": issuer + "/v1/keys",
+		}))
+
+	verifier, err := (&JwtVerifier{Issuer: issuer, Discovery: oauth2.OAuth2{}.New()}).New()
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, err = verifier.VerifyAccessToken(signToken(t, key, jwa.RS256, nil, validClaims(issuer)))
+		require.NoError(t, err)
+	}
+
+	info := httpmock.GetCallCountInfo()
+	require.Equal(t, 1, info["GET https://example.com/.well-known/oauth-authorization-server/oauth2/default"])
+	require.Equal(t, 1, info["GET "+issuer+"/.well-known/oauth-authorization-server"])
+	require.Zero(t, info["GET "+issuer+"/.well-known/openid-configuration"])
+}
+
+func TestVerifiersShareFetchedResources(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	key := newSigningKey(t, "key", jwa.RS256)
+	mockIssuer(t, issuer, key)
+
+	store := utils.NewMemoryStore(time.Minute)
+	for i := 0; i < 3; i++ {
+		verifier, err := (&JwtVerifier{Issuer: issuer, SharedCache: store}).New()
+		require.NoError(t, err)
+		_, err = verifier.VerifyAccessToken(signToken(t, key, jwa.RS256, nil, validClaims(issuer)))
+		require.NoError(t, err)
+	}
+
+	info := httpmock.GetCallCountInfo()
+	require.Equal(t, 1, info["GET "+issuer+"/.well-known/openid-configuration"])
+	require.Equal(t, 1, info["GET "+issuer+"/v1/keys"])
+
+	// metadata shared by a verifier of another issuer is not trusted
+	other, err := (&JwtVerifier{Issuer: "https://other.example.com", SharedCache: store}).New()
+	require.NoError(t, err)
+	value, found, err := store.Get(context.Background(), "okta-jwt-verifier:metadata:"+issuer+"/.well-known/openid-configuration")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.NoError(t, store.Set(context.Background(), "okta-jwt-verifier:metadata:https://other.example.com/.well-known/openid-configuration", value, time.Minute))
+	_, err = other.Metadata(context.Background())
+	require.ErrorIs(t, err, oktaErrors.ErrMetadataUnavailable)
+}
+
+func TestUnknownKidRefreshIsKeptWithCustomCache(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	oldKey := newSigningKey(t, "old", jwa.RS256)
+	newKey := newSigningKey(t, "new", jwa.RS256)
+	mockIssuer(t, issuer, oldKey)
+
+	published := []jwk.Key{oldKey}
+	httpmock.RegisterResponder("GET", issuer+"/v1/keys", func(req *http.Request) (*http.Response, error) {
+		set := jwk.NewSet()
+		for _, key := range published {
+			pub, err := jwk.PublicKeyOf(key)
+			require.NoError(t, err)
+			require.NoError(t, set.AddKey(pub))
+		}
+		return httpmock.NewJsonResponse(200, set)
+	})
+
+	jv, err := (&JwtVerifier{Issuer: issuer, Cache: newMapCache}).New()
+	require.NoError(t, err)
+
+	_, err = jv.VerifyAccessToken(signToken(t, oldKey, jwa.RS256, nil, validClaims(issuer)))
+	require.NoError(t, err)
+
+	// the map cache cannot be refreshed and keeps serving the old set
+	published = append(published, newKey)
+	for i := 0; i < 2; i++ {
+		_, err = jv.VerifyAccessToken(signToken(t, newKey, jwa.RS256, nil, validClaims(issuer)))
+		require.NoError(t, err)
+	}
+	require.Equal(t, 2, httpmock.GetCallCountInfo()["GET "+issuer+"/v1/keys"])
+}
+
+// mapCache is a Cache without Refresh that never expires its values.
+type mapCache struct {
+	values map[string]interface{}
+	lookup func(string) (interface{}, error)
+}
+
+func newMapCache(lookup func(string) (interface{}, error), timeout, cleanup time.Duration) (utils.Cacher, error) {
+	return &mapCache{values: map[string]interface{}{}, lookup: lookup}, nil
+}
+
+func (c *mapCache) Get(key string) (interface{}, error) {
+	if value, ok := c.values[key]; ok {
+		return value, nil
+	}
+	value, err := c.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+	c.values[key] = value
+	return value, nil
+}
@@ -0,0 +1,119 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package jwtverifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+)
+
+// ProviderMetadata is the authorization server metadata of the issuer, as
+// defined by OpenID Connect Discovery and RFC 8414.
+type ProviderMetadata struct {
+	Issuer                string `json:"issuer"`
+	JwksUri               string `json:"jwks_uri"`
+	AuthorizationEndpoint string `json:"authorization_endpoint,omitempty"`
+	TokenEndpoint         string `json:"token_endpoint,omitempty"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint,omitempty"`
+	IntrospectionEndpoint string `json:"introspection_endpoint,omitempty"`
+	RevocationEndpoint    string `json:"revocation_endpoint,omitempty"`
+	EndSessionEndpoint    string `json:"end_session_endpoint,omitempty"`
+	RegistrationEndpoint  string `json:"registration_endpoint,omitempty"`
+
+	ScopesSupported                   []string `json:"scopes_supported,omitempty"`
+	ClaimsSupported                   []string `json:"claims_supported,omitempty"`
+	ResponseTypesSupported            []string `json:"response_types_supported,omitempty"`
+	GrantTypesSupported               []string `json:"grant_types_supported,omitempty"`
+	SubjectTypesSupported             []string `json:"subject_types_supported,omitempty"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported,omitempty"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported,omitempty"`
+	IdTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported,omitempty"`
+	DPoPSigningAlgValuesSupported     []string `json:"dpop_signing_alg_values_supported,omitempty"`
+
+	// Raw holds every member of the metadata document, including those
+	// without a field above. It must not be modified.
+	Raw map[string]interface{} `json:"-"`
+}
+
+// Metadata returns the metadata of the issuer, fetching it unless it is
+// cached or was given in MetadataFile. The issuer in the metadata is known
+// to be the configured Issuer, and its jwks_uri, if any, to use https.
+func (j *JwtVerifier) Metadata(ctx context.Context) (*ProviderMetadata, error) {
+	metadata, err := j.getMetaData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m := *metadata
+	return &m, nil
+}
+
+func decodeProviderMetadata(data []byte) (*ProviderMetadata, error) {
+	metadata := &ProviderMetadata{}
+	if err := json.Unmarshal(data, metadata); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &metadata.Raw); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// validateMetadata makes sure metadata belongs to the configured issuer, so
+// that an authorization server cannot pass its keys off as another's (the
+// mix-up attack of RFC 8414 section 3.3), and that keys are only ever fetched
+// over https.
+func (j *JwtVerifier) validateMetadata(metadata *ProviderMetadata) error {
+	if metadata.Issuer != j.Issuer {
+		return errors.Mark(fmt.Errorf("metadata issuer %q does not match the issuer %q", metadata.Issuer, j.Issuer), errors.ErrMetadataUnavailable)
+	}
+	if metadata.JwksUri != "" {
+		u, err := url.Parse(metadata.JwksUri)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return errors.Mark(fmt.Errorf("metadata jwks_uri %q is not an https URL", metadata.JwksUri), errors.ErrMetadataUnavailable)
+		}
+	}
+	return nil
+}
+
+// metadataCodec encodes metadata for a shared cache. Metadata read from the
+// cache is validated again, as other verifiers may write to it.
+type metadataCodec struct {
+	verifier *JwtVerifier
+}
+
+func (c metadataCodec) Marshal(value interface{}) ([]byte, error) {
+	metadata, ok := value.(*ProviderMetadata)
+	if !ok {
+		return nil, fmt.Errorf("unable to cast %v to metadata", value)
+	}
+	return json.Marshal(metadata.Raw)
+}
+
+func (c metadataCodec) Unmarshal(data []byte) (interface{}, error) {
+	metadata, err := decodeProviderMetadata(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.verifier.validateMetadata(metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
@@ -0,0 +1,100 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package jwtverifier
+
+import (
+	"context"
+	"testing"
+
+	oktaErrors "github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+	"github.com/jarcoal/httpmock"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadata(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	httpmock.RegisterResponder("GET", issuer+"/.well-known/openid-configuration",
+		httpmock.NewJsonResponderOrPanic(200, map[string]interface{}{
+			"issuer":                                issuer,
+			"jwks_uri":                              issuer + "/v1/keys",
+			"token_endpoint":                        issuer + "/v1/token",
+			"scopes_supported":                      []string{"openid", "profile"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+			"x-vendor-extension":                    true,
+		}))
+
+	verifier, err := (&JwtVerifier{Issuer: issuer}).New()
+	require.NoError(t, err)
+
+	metadata, err := verifier.Metadata(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, issuer, metadata.Issuer)
+	require.Equal(t, issuer+"/v1/keys", metadata.JwksUri)
+	require.Equal(t, issuer+"/v1/token", metadata.TokenEndpoint)
+	require.Equal(t, []string{"openid", "profile"}, metadata.ScopesSupported)
+	require.Equal(t, []string{"RS256"}, metadata.IdTokenSigningAlgValuesSupported)
+	require.Equal(t, true, metadata.Raw["x-vendor-extension"])
+}
+
+func TestMetadataIsValidated(t *testing.T) {
+	issuer := "https://example.com/oauth2/default"
+	tests := map[string]map[string]interface{}{
+		"another issuer": {
+			"issuer":   "https://evil.example.com/oauth2/default",
+			"jwks_uri": "https://evil.example.com/oauth2/default/v1/keys",
+		},
+		"an issuer that differs by a trailing slash": {
+			"issuer":   issuer + "/",
+			"jwks_uri": issuer + "/v1/keys",
+		},
+		"a plain http jwks_uri": {
+			"issuer":   issuer,
+			"jwks_uri": "http://example.com/oauth2/default/v1/keys",
+		},
+	}
+
+	for name, document := range tests {
+		t.Run(name, func(t *testing.T) {
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+
+			key := newSigningKey(t, "key", jwa.RS256)
+			mockIssuer(t, issuer, key)
+			httpmock.RegisterResponder("GET", issuer+"/.well-known/openid-configuration",
+				httpmock.NewJsonResponderOrPanic(200, document))
+
+			verifier, err := (&JwtVerifier{Issuer: issuer}).New()
+			require.NoError(t, err)
+
+			_, err = verifier.Metadata(context.Background())
+			require.ErrorIs(t, err, oktaErrors.ErrMetadataUnavailable)
+
+			_, err = verifier.VerifyAccessToken(signToken(t, key, jwa.RS256, nil, validClaims(issuer)))
+			require.ErrorIs(t, err, oktaErrors.ErrMetadataUnavailable)
+			require.Zero(t, httpmock.GetCallCountInfo()["GET "+issuer+"/v1/keys"])
+		})
+	}
+}
+
+func TestIssuerWithTrailingSlashIsRejectedByNew(t *testing.T) {
+	_, err := (&JwtVerifier{Issuer: "https://example.com/oauth2/default/"}).New()
+	require.ErrorContains(t, err, "must not end with a slash")
+}
@@ -0,0 +1,355 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+// Package middleware protects net/http handlers with bearer access tokens.
+package middleware
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	jwtverifier "github.com/hung12ct/okta-jwt-verifier-golang/v2"
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+)
+
+// ErrMissingToken is returned by a TokenExtractor when the request carries no
+// access token.
+var ErrMissingToken = stderrors.New("no access token present in request")
+
+// Verifier verifies access tokens. *jwtverifier.JwtVerifier implements it.
+type Verifier interface {
+	VerifyAccessTokenContext(ctx context.Context, jwt string, opts ...jwtverifier.VerifyOption) (*jwtverifier.Jwt, error)
+}
+
+// DPoPVerifier verifies DPoP-bound access tokens together with their proof.
+// *jwtverifier.JwtVerifier implements it.
+type DPoPVerifier interface {
+	VerifyDPoP(ctx context.Context, accessToken, proof, method, url string, opts ...jwtverifier.VerifyOption) (*jwtverifier.Jwt, error)
+}
+
+// TokenExtractor pulls the access token out of a request.
+type TokenExtractor func(r *http.Request) (string, error)
+
+// ErrorHandler writes the response for a request that failed verification.
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+
+// Option configures a Middleware.
+type Option func(*Middleware)
+
+// WithTokenExtractor replaces the default extractor, which reads a bearer
+// token from the Authorization header.
+func WithTokenExtractor(extractor TokenExtractor) Option {
+	return func(m *Middleware) {
+		m.extractor = extractor
+	}
+}
+
+// WithErrorHandler replaces the default error rendering.
+func WithErrorHandler(handler ErrorHandler) Option {
+	return func(m *Middleware) {
+		m.errorHandler = handler
+	}
+}
+
+// WithRealm sets the realm reported in WWW-Authenticate challenges.
+func WithRealm(realm string) Option {
+	return func(m *Middleware) {
+		m.realm = realm
+	}
+}
+
+// WithVerifyOptions passes opts to every verification.
+func WithVerifyOptions(opts ...jwtverifier.VerifyOption) Option {
+	return func(m *Middleware) {
+		m.verifyOptions = append(m.verifyOptions, opts...)
+	}
+}
+
+// WithRequiredScopes rejects tokens that do not grant every one of scopes.
+func WithRequiredScopes(scopes ...string) Option {
+	return WithVerifyOptions(jwtverifier.WithRequiredScopes(scopes...))
+}
+
+// WithAnyScope rejects tokens that grant none of scopes.
+func WithAnyScope(scopes ...string) Option {
+	return WithVerifyOptions(jwtverifier.WithAnyScope(scopes...))
+}
+
+// WithDPoP accepts DPoP-bound access tokens sent with the DPoP authorization
+// scheme (RFC 9449) next to bearer tokens, verifying them with verifier, and
+// rejects bound tokens sent as bearer tokens. verifier is usually the one
+// given to New.
+func WithDPoP(verifier DPoPVerifier) Option {
+	return func(m *Middleware) {
+		m.dpopVerifier = verifier
+	}
+}
+
+// WithRequestURL replaces how the URL a DPoP proof is checked against is
+// derived from the request. Use it when a proxy changes the scheme, host or
+// path the client used.
+func WithRequestURL(requestURL func(r *http.Request) string) Option {
+	return func(m *Middleware) {
+		m.requestURL = requestURL
+	}
+}
+
+// WithCertificateBinding rejects access tokens that are not bound to the
+// client certificate of the request's mutual TLS connection (RFC 8705). The
+// server must request client certificates for this to succeed.
+func WithCertificateBinding() Option {
+	return func(m *Middleware) {
+		m.certificateBinding = true
+	}
+}
+
+// Middleware verifies the access token of every request before handing it to
+// the wrapped handler.
+type Middleware struct {
+	verifier      Verifier
+	extractor     TokenExtractor
+	errorHandler  ErrorHandler
+	realm         string
+	verifyOptions []jwtverifier.VerifyOption
+	dpopVerifier  DPoPVerifier
+	requestURL    func(r *http.Request) string
+
+	certificateBinding bool
+}
+
+// New returns a Middleware that verifies tokens with verifier.
+func New(verifier Verifier, opts ...Option) *Middleware {
+	m := &Middleware{
+		verifier:   verifier,
+		extractor:  BearerToken,
+		requestURL: RequestURL,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.errorHandler == nil {
+		m.errorHandler = m.writeError
+	}
+	return m
+}
+
+// Handler wraps next so that it is only called for requests with a valid
+// access token. The verified token is available to next through
+// JwtFromContext.
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jwt, err := m.verify(r)
+		if err != nil {
+			m.errorHandler(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), jwt)))
+	})
+}
+
+func (m *Middleware) verify(r *http.Request) (*jwtverifier.Jwt, error) {
+	opts := m.verifyOptions
+	if m.certificateBinding {
+		opts = append(opts[:len(opts):len(opts)], jwtverifier.WithTLSConnectionState(r.TLS))
+	}
+
+	if m.dpopVerifier != nil {
+		if token, ok := dpopToken(r); ok {
+			// RFC 9449 section 4.3: exactly one DPoP header is allowed
+			var proof string
+			if proofs := r.Header.Values("DPoP"); len(proofs) == 1 {
+				proof = proofs[0]
+			}
+			return m.dpopVerifier.VerifyDPoP(r.Context(), token, proof, r.Method, m.requestURL(r), opts...)
+		}
+	}
+
+	token, err := m.extractor(r)
+	if err != nil {
+		return nil, err
+	}
+
+	jwt, err := m.verifier.VerifyAccessTokenContext(r.Context(), token, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.dpopVerifier != nil {
+		if cnf, ok := jwt.Claims["cnf"].(map[string]interface{}); ok && cnf["jkt"] != nil {
+			return nil, errors.Mark(stderrors.New("a DPoP-bound access token was sent as a bearer token"), errors.ErrTokenBindingMismatch)
+		}
+	}
+	return jwt, nil
+}
+
+func (m *Middleware) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status := Status(err)
+	if !stderrors.Is(err, errors.ErrInvalidDPoPProof) {
+		if challenge := Challenge(m.realm, err); challenge != "" {
+			w.Header().Add("WWW-Authenticate", challenge)
+		}
+	}
+	if m.dpopVerifier != nil {
+		if challenge := DPoPChallenge(m.realm, err); challenge != "" {
+			w.Header().Add("WWW-Authenticate", challenge)
+		}
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+func dpopToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "DPoP") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// RequestURL returns the URL of r as the client sent it, without its query,
+// assuming the server is reached directly.
+func RequestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.EscapedPath()
+}
+
+// BearerToken extracts a bearer token from the Authorization header as
+// described in RFC 6750 section 2.1.
+func BearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", ErrMissingToken
+	}
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", ErrMissingToken
+	}
+	return token, nil
+}
+
+// Status returns the HTTP status code for a verification error.
+func Status(err error) int {
+	switch {
+	case stderrors.Is(err, ErrMissingToken):
+		return http.StatusUnauthorized
+	case stderrors.Is(err, errors.ErrInsufficientScope):
+		return http.StatusForbidden
+	case stderrors.Is(err, errors.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusUnauthorized
+	}
+}
+
+// Challenge returns the RFC 6750 WWW-Authenticate header value for a
+// verification error, or "" when no challenge applies.
+func Challenge(realm string, err error) string {
+	return challenge("Bearer", realm, err)
+}
+
+// DPoPChallenge returns the RFC 9449 WWW-Authenticate header value with the
+// DPoP scheme for a verification error, or "" when no challenge applies.
+func DPoPChallenge(realm string, err error) string {
+	return challenge("DPoP", realm, err)
+}
+
+func challenge(scheme, realm string, err error) string {
+	var params []string
+	if realm != "" {
+		params = append(params, fmt.Sprintf("realm=%q", sanitize(realm)))
+	}
+
+	switch {
+	case stderrors.Is(err, ErrMissingToken):
+		// RFC 6750 section 3.1: no error code when no credentials were sent.
+	case stderrors.Is(err, errors.ErrInsufficientScope):
+		params = append(params,
+			`error="insufficient_scope"`,
+			`error_description="The access token does not grant the required scope"`)
+		var insufficient *errors.InsufficientScope
+		if stderrors.As(err, &insufficient) {
+			params = append(params, fmt.Sprintf("scope=%q", sanitize(strings.Join(insufficient.Missing, " "))))
+		}
+	case stderrors.Is(err, errors.ErrInsufficientUserAuthentication):
+		// RFC 9470 section 3
+		description := "A different authentication level is required"
+		if stderrors.Is(err, errors.ErrAuthenticationTooOld) {
+			description = "More recent authentication is required"
+		}
+		params = append(params,
+			`error="insufficient_user_authentication"`,
+			fmt.Sprintf("error_description=%q", description))
+		var insufficient *errors.InsufficientUserAuthentication
+		if stderrors.As(err, &insufficient) {
+			if len(insufficient.AcrValues) > 0 {
+				params = append(params, fmt.Sprintf("acr_values=%q", sanitize(strings.Join(insufficient.AcrValues, " "))))
+			}
+			if insufficient.MaxAge > 0 {
+				params = append(params, fmt.Sprintf("max_age=\"%d\"", int64(insufficient.MaxAge.Seconds())))
+			}
+		}
+	case stderrors.Is(err, errors.ErrUnavailable):
+		return ""
+	case stderrors.Is(err, errors.ErrInvalidDPoPProof):
+		params = append(params,
+			`error="invalid_dpop_proof"`,
+			`error_description="The DPoP proof is invalid"`)
+	case stderrors.Is(err, errors.ErrTokenExpired):
+		params = append(params,
+			`error="invalid_token"`,
+			`error_description="The access token expired"`)
+	default:
+		params = append(params,
+			`error="invalid_token"`,
+			`error_description="The access token is invalid"`)
+	}
+
+	if len(params) == 0 {
+		return scheme
+	}
+	return scheme + " " + strings.Join(params, ", ")
+}
+
+// sanitize drops the characters RFC 6750 does not allow in auth-param values.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying jwt.
+func NewContext(ctx context.Context, jwt *jwtverifier.Jwt) context.Context {
+	return context.WithValue(ctx, contextKey{}, jwt)
+}
+
+// JwtFromContext returns the verified token stored in ctx by the middleware.
+func JwtFromContext(ctx context.Context) (*jwtverifier.Jwt, bool) {
+	jwt, ok := ctx.Value(contextKey{}).(*jwtverifier.Jwt)
+	return jwt, ok
+}
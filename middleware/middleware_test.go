@@ -0,0 +1,275 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package middleware_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwtverifier "github.com/hung12ct/okta-jwt-verifier-golang/v2"
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/middleware"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeVerifier map[string]error
+
+func (f fakeVerifier) VerifyAccessTokenContext(ctx context.Context, jwt string, opts ...jwtverifier.VerifyOption) (*jwtverifier.Jwt, error) {
+	if err, ok := f[jwt]; ok && err != nil {
+		return nil, err
+	}
+	return &jwtverifier.Jwt{Claims: map[string]interface{}{"sub": "user@example.com"}}, nil
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	jwt, ok := middleware.JwtFromContext(r.Context())
+	if !ok {
+		http.Error(w, "no jwt in context", http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, jwt.Claims["sub"])
+})
+
+func serve(t *testing.T, handler http.Handler, authorization string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMiddleware(t *testing.T) {
+	verifier := fakeVerifier{
+		"expired":     fmt.Errorf("the `Expiration` was not able to be validated. %w", errors.ErrTokenExpired),
+		"bad":         errors.ErrInvalidSignature,
+		"unavailable": errors.ErrMetadataUnavailable,
+		"scope":       errors.ErrInsufficientScope,
+	}
+	handler := middleware.New(verifier, middleware.WithRealm("api")).Handler(okHandler)
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+		challenge     string
+	}{
+		{"valid", "Bearer good", http.StatusOK, ""},
+		{"lower case scheme", "bearer good", http.StatusOK, ""},
+		{"missing", "", http.StatusUnauthorized, `Bearer realm="api"`},
+		{"wrong scheme", "Basic dXNlcjpwYXNz", http.StatusUnauthorized, `Bearer realm="api"`},
+		{"expired", "Bearer expired", http.StatusUnauthorized, `Bearer realm="api", error="invalid_token", error_description="The access token expired"`},
+		{"invalid", "Bearer bad", http.StatusUnauthorized, `Bearer realm="api", error="invalid_token", error_description="The access token is invalid"`},
+		{"insufficient scope", "Bearer scope", http.StatusForbidden, `Bearer realm="api", error="insufficient_scope", error_description="The access token does not grant the required scope"`},
+		{"unavailable", "Bearer unavailable", http.StatusServiceUnavailable, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, handler, tt.authorization)
+			require.Equal(t, tt.status, rec.Code)
+			require.Equal(t, tt.challenge, rec.Header().Get("WWW-Authenticate"))
+			if tt.status == http.StatusOK {
+				require.Equal(t, "user@example.com", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestMiddlewareInsufficientUserAuthentication(t *testing.T) {
+	verifier := fakeVerifier{
+		"stale": &errors.InsufficientUserAuthentication{Kind: errors.ErrAuthenticationTooOld, Message: "auth_time: missing", MaxAge: 5 * time.Minute},
+		"weak":  &errors.InsufficientUserAuthentication{Kind: errors.ErrAcrNotAccepted, Message: "acr: pwd", AcrValues: []string{"urn:okta:loa:2fa:any"}},
+	}
+	handler := middleware.New(verifier).Handler(okHandler)
+
+	rec := serve(t, handler, "Bearer stale")
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.Equal(t, `Bearer error="insufficient_user_authentication", error_description="More recent authentication is required", max_age="300"`,
+		rec.Header().Get("WWW-Authenticate"))
+
+	rec = serve(t, handler, "Bearer weak")
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.Equal(t, `Bearer error="insufficient_user_authentication", error_description="A different authentication level is required", acr_values="urn:okta:loa:2fa:any"`,
+		rec.Header().Get("WWW-Authenticate"))
+}
+
+func TestMiddlewareOptions(t *testing.T) {
+	var handled error
+	handler := middleware.New(fakeVerifier{"bad": errors.ErrInvalidSignature},
+		middleware.WithTokenExtractor(func(r *http.Request) (string, error) {
+			return r.URL.Query().Get("token"), nil
+		}),
+		middleware.WithErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+			handled = err
+			w.WriteHeader(http.StatusTeapot)
+		}),
+	).Handler(okHandler)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?token=good", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?token=bad", nil))
+	require.Equal(t, http.StatusTeapot, rec.Code)
+	require.ErrorIs(t, handled, errors.ErrInvalidSignature)
+}
+
+func TestMiddlewareRequiredScopes(t *testing.T) {
+	verifier := scopeVerifier{"openid", "profile"}
+
+	handler := middleware.New(verifier, middleware.WithRequiredScopes("profile", "email", "groups")).Handler(okHandler)
+	rec := serve(t, handler, "Bearer good")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Equal(t, `Bearer error="insufficient_scope", error_description="The access token does not grant the required scope", scope="email groups"`,
+		rec.Header().Get("WWW-Authenticate"))
+
+	handler = middleware.New(verifier, middleware.WithAnyScope("admin", "profile")).Handler(okHandler)
+	rec = serve(t, handler, "Bearer good")
+	require.Equal(t, http.StatusOK, rec.Code)
+}
+
+// scopeVerifier accepts any token and grants the listed scopes, applying the
+// verify options the way JwtVerifier does.
+type scopeVerifier []string
+
+func (s scopeVerifier) VerifyAccessTokenContext(ctx context.Context, jwt string, opts ...jwtverifier.VerifyOption) (*jwtverifier.Jwt, error) {
+	scp := make([]interface{}, len(s))
+	for i, scope := range s {
+		scp[i] = scope
+	}
+	token := &jwtverifier.Jwt{Claims: map[string]interface{}{"sub": "user@example.com", "scp": scp}}
+	if err := jwtverifier.ValidateOptions(token, opts...); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// dpopVerifier accepts the bearer token "good", the bearer token "bound"
+// which is DPoP-bound, and DPoP requests whose proof is "proof".
+type dpopVerifier struct {
+	url string
+}
+
+func (d *dpopVerifier) VerifyAccessTokenContext(ctx context.Context, jwt string, opts ...jwtverifier.VerifyOption) (*jwtverifier.Jwt, error) {
+	claims := map[string]interface{}{"sub": "user@example.com"}
+	switch jwt {
+	case "good":
+	case "bound":
+		claims["cnf"] = map[string]interface{}{"jkt": "thumbprint"}
+	default:
+		return nil, errors.ErrInvalidSignature
+	}
+	return &jwtverifier.Jwt{Claims: claims}, nil
+}
+
+func (d *dpopVerifier) VerifyDPoP(ctx context.Context, accessToken, proof, method, url string, opts ...jwtverifier.VerifyOption) (*jwtverifier.Jwt, error) {
+	d.url = url
+	if proof != "proof" {
+		return nil, errors.ErrInvalidDPoPProof
+	}
+	return d.VerifyAccessTokenContext(ctx, accessToken, opts...)
+}
+
+func TestMiddlewareDPoP(t *testing.T) {
+	verifier := &dpopVerifier{}
+	handler := middleware.New(verifier, middleware.WithDPoP(verifier)).Handler(okHandler)
+
+	send := func(authorization string, proofs ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "https://api.example.com/orders?page=2", nil)
+		req.Header.Set("Authorization", authorization)
+		for _, proof := range proofs {
+			req.Header.Add("DPoP", proof)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := send("DPoP bound", "proof")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "https://api.example.com/orders", verifier.url)
+
+	rec = send("DPoP bound", "forged")
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.Equal(t, []string{`DPoP error="invalid_dpop_proof", error_description="The DPoP proof is invalid"`},
+		rec.Header().Values("WWW-Authenticate"))
+
+	// RFC 9449 allows a single DPoP header only
+	rec = send("DPoP bound", "proof", "proof")
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// bearer tokens keep working, unless they are DPoP-bound
+	rec = send("Bearer good")
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = send("Bearer bound")
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.Equal(t, []string{
+		`Bearer error="invalid_token", error_description="The access token is invalid"`,
+		`DPoP error="invalid_token", error_description="The access token is invalid"`,
+	}, rec.Header().Values("WWW-Authenticate"))
+}
+
+func TestMiddlewareCertificateBinding(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	verifier := certificateVerifier(jwtverifier.CertificateThumbprint(cert))
+	handler := middleware.New(verifier, middleware.WithCertificateBinding()).Handler(okHandler)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer good")
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serve(t, handler, "Bearer good")
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+// certificateVerifier accepts any token, bound to the certificate with the
+// given thumbprint, applying the verify options the way JwtVerifier does.
+type certificateVerifier string
+
+func (c certificateVerifier) VerifyAccessTokenContext(ctx context.Context, jwt string, opts ...jwtverifier.VerifyOption) (*jwtverifier.Jwt, error) {
+	token := &jwtverifier.Jwt{Claims: map[string]interface{}{
+		"sub": "user@example.com",
+		"cnf": map[string]interface{}{"x5t#S256": string(c)},
+	}}
+	if err := jwtverifier.ValidateOptions(token, opts...); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
@@ -0,0 +1,76 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package jwtverifier
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+)
+
+// WithClientCertificate requires the access token to be bound to cert, the
+// client certificate of a mutual TLS connection (RFC 8705): the token's
+// cnf["x5t#S256"] claim must be the SHA-256 thumbprint of cert. A nil cert
+// rejects every token.
+func WithClientCertificate(cert *x509.Certificate) VerifyOption {
+	return func(o *verifyOptions) {
+		o.certificateBound = true
+		o.certificate = cert
+	}
+}
+
+// WithTLSConnectionState is like WithClientCertificate, using the leaf
+// certificate the client presented on the connection described by state.
+func WithTLSConnectionState(state *tls.ConnectionState) VerifyOption {
+	var cert *x509.Certificate
+	if state != nil && len(state.PeerCertificates) > 0 {
+		cert = state.PeerCertificates[0]
+	}
+	return WithClientCertificate(cert)
+}
+
+// CertificateThumbprint returns the base64url encoded SHA-256 thumbprint of
+// cert, as used in the x5t#S256 confirmation method.
+func CertificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (o *verifyOptions) validateCertificateBinding(claims map[string]interface{}) error {
+	if !o.certificateBound {
+		return nil
+	}
+	if o.certificate == nil {
+		return errors.Mark(fmt.Errorf("no client certificate was presented"), errors.ErrTokenBindingMismatch)
+	}
+
+	cnf, _ := claims["cnf"].(map[string]interface{})
+	x5t, _ := cnf["x5t#S256"].(string)
+	if x5t == "" {
+		return errors.Mark(fmt.Errorf("cnf.x5t#S256: missing"), errors.ErrTokenBindingMismatch)
+	}
+	thumbprint := CertificateThumbprint(o.certificate)
+	if subtle.ConstantTimeCompare([]byte(x5t), []byte(thumbprint)) != 1 {
+		return errors.Mark(fmt.Errorf("cnf.x5t#S256: %s does not match the client certificate %s", x5t, thumbprint), errors.ErrTokenBindingMismatch)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package jwtverifier
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	oktaErrors "github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+	"github.com/jarcoal/httpmock"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/stretchr/testify/require"
+)
+
+func newClientCertificate(t *testing.T, name string) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
+
+func TestCertificateBoundAccessToken(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	key := newSigningKey(t, "key", jwa.RS256)
+	mockIssuer(t, issuer, key)
+
+	verifier, err := (&JwtVerifier{Issuer: issuer}).New()
+	require.NoError(t, err)
+
+	cert := newClientCertificate(t, "client")
+	other := newClientCertificate(t, "other")
+
+	claims := validClaims(issuer)
+	claims["cnf"] = map[string]interface{}{"x5t#S256": CertificateThumbprint(cert)}
+	bound := signToken(t, key, jwa.RS256, nil, claims)
+	unbound := signToken(t, key, jwa.RS256, nil, validClaims(issuer))
+
+	_, err = verifier.VerifyAccessToken(bound, WithClientCertificate(cert))
+	require.NoError(t, err)
+	_, err = verifier.VerifyAccessToken(bound, WithTLSConnectionState(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		token string
+		opt   VerifyOption
+	}{
+		{"other certificate", bound, WithClientCertificate(other)},
+		{"no certificate", bound, WithTLSConnectionState(&tls.ConnectionState{})},
+		{"no connection", bound, WithTLSConnectionState(nil)},
+		{"unbound token", unbound, WithClientCertificate(cert)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifier.VerifyAccessToken(tt.token, tt.opt)
+			require.ErrorIs(t, err, oktaErrors.ErrTokenBindingMismatch)
+			require.ErrorIs(t, err, oktaErrors.ErrInvalidToken)
+		})
+	}
+
+	// without the option the binding is not checked
+	_, err = verifier.VerifyAccessToken(bound)
+	require.NoError(t, err)
+}
@@ -0,0 +1,158 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package jwtverifier
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+)
+
+// MultiIssuerVerifier verifies tokens from several authorization servers. It
+// reads the unverified iss claim of each token and hands the token to the
+// JwtVerifier configured for that issuer. Tokens from any other issuer are
+// rejected before anything is fetched.
+type MultiIssuerVerifier struct {
+	// Verifiers holds one verifier setup per accepted issuer, each with its
+	// own ClaimsToValidate and caches. New calls New on every one of them.
+	Verifiers []*JwtVerifier
+
+	byIssuer map[string]*JwtVerifier
+}
+
+func (m *MultiIssuerVerifier) New() (*MultiIssuerVerifier, error) {
+	m.byIssuer = make(map[string]*JwtVerifier, len(m.Verifiers))
+	for _, setup := range m.Verifiers {
+		if setup.Issuer == "" {
+			return nil, fmt.Errorf("every verifier must have an Issuer")
+		}
+		issuer := normalizeIssuer(setup.Issuer)
+		if _, exists := m.byIssuer[issuer]; exists {
+			return nil, fmt.Errorf("issuer %s is configured more than once", issuer)
+		}
+		verifier, err := setup.New()
+		if err != nil {
+			return nil, fmt.Errorf("could not set up verifier for %s: %w", issuer, err)
+		}
+		m.byIssuer[issuer] = verifier
+	}
+	return m, nil
+}
+
+func (m *MultiIssuerVerifier) VerifyAccessToken(jwt string, opts ...VerifyOption) (*Jwt, error) {
+	return m.VerifyAccessTokenContext(context.Background(), jwt, opts...)
+}
+
+func (m *MultiIssuerVerifier) VerifyAccessTokenContext(ctx context.Context, jwt string, opts ...VerifyOption) (*Jwt, error) {
+	verifier, err := m.verifierFor(jwt)
+	if err != nil {
+		return nil, err
+	}
+	return verifier.VerifyAccessTokenContext(ctx, jwt, opts...)
+}
+
+func (m *MultiIssuerVerifier) VerifyIdToken(jwt string, opts ...VerifyOption) (*Jwt, error) {
+	return m.VerifyIdTokenContext(context.Background(), jwt, opts...)
+}
+
+// VerifyIdTokenContext routes an encrypted ID token by the issuer of the
+// token nested in it, which is decrypted with the DecryptionKeys of each
+// verifier in turn.
+func (m *MultiIssuerVerifier) VerifyIdTokenContext(ctx context.Context, jwt string, opts ...VerifyOption) (*Jwt, error) {
+	if isEncryptedJwt(jwt) {
+		nested, err := m.decryptJwt(jwt)
+		if err != nil {
+			return nil, fmt.Errorf("token is not valid: %w", err)
+		}
+		jwt = nested
+	}
+
+	verifier, err := m.verifierFor(jwt)
+	if err != nil {
+		return nil, err
+	}
+	return verifier.VerifyIdTokenContext(ctx, jwt, opts...)
+}
+
+// Close closes every per-issuer verifier.
+func (m *MultiIssuerVerifier) Close() error {
+	for _, verifier := range m.byIssuer {
+		if err := verifier.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MultiIssuerVerifier) verifierFor(jwt string) (*JwtVerifier, error) {
+	if jwt == "" {
+		return nil, fmt.Errorf("token is not valid: %w", errors.JwtEmptyStringError())
+	}
+	issuer, err := unverifiedIssuer(jwt)
+	if err != nil {
+		return nil, fmt.Errorf("token is not valid: %w", err)
+	}
+	verifier, ok := m.byIssuer[normalizeIssuer(issuer)]
+	if !ok {
+		return nil, errors.Mark(fmt.Errorf("the `Issuer` was not able to be validated. iss: %s is not an accepted issuer", issuer), errors.ErrIssuerMismatch)
+	}
+	return verifier, nil
+}
+
+// decryptJwt decrypts jwt with the first verifier whose DecryptionKeys fit,
+// since which issuer the token comes from is only known afterwards.
+func (m *MultiIssuerVerifier) decryptJwt(jwt string) (string, error) {
+	err := errors.Mark(fmt.Errorf("the token is encrypted but no DecryptionKeys are configured"), errors.ErrDecryptionFailed)
+	for _, verifier := range m.Verifiers {
+		if len(verifier.DecryptionKeys) == 0 {
+			continue
+		}
+		var nested string
+		nested, err = verifier.decryptJwt(jwt)
+		if err == nil {
+			return nested, nil
+		}
+	}
+	return "", err
+}
+
+// unverifiedIssuer reads the iss claim from the payload of jwt without
+// checking the signature. The result may only be used to pick the verifier.
+func unverifiedIssuer(jwt string) (string, error) {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return "", errors.Mark(fmt.Errorf("token must have 3 parts, it has %d", len(parts)), errors.ErrMalformedToken)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return "", errors.Mark(fmt.Errorf("the tokens payload does not appear to be a base64 encoded string"), errors.ErrMalformedToken)
+	}
+	var claims struct {
+		Issuer string `json:"iss"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", errors.Mark(fmt.Errorf("the tokens payload is not a json object"), errors.ErrMalformedToken)
+	}
+	if claims.Issuer == "" {
+		return "", errors.Mark(fmt.Errorf("iss: missing"), errors.ErrMissingClaim)
+	}
+	return claims.Issuer, nil
+}
@@ -0,0 +1,132 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package jwtverifier
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	oktaErrors "github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+	"github.com/jarcoal/httpmock"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiIssuerVerifier(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	first := "https://first.example.com/oauth2/default"
+	second := "https://second.example.com/oauth2/ausabc"
+	unknown := "https://unknown.example.com/oauth2/default"
+	firstKey := newSigningKey(t, "first", jwa.RS256)
+	secondKey := newSigningKey(t, "second", jwa.RS256)
+	unknownKey := newSigningKey(t, "unknown", jwa.RS256)
+	mockIssuer(t, first, firstKey)
+	mockIssuer(t, second, secondKey)
+
+	mv := MultiIssuerVerifier{Verifiers: []*JwtVerifier{
+		{Issuer: first, ClaimsToValidate: map[string]string{"aud": "api://default"}},
+		{Issuer: second, ClaimsToValidate: map[string]string{"aud": "api://second"}},
+	}}
+	verifier, err := mv.New()
+	require.NoError(t, err)
+	defer verifier.Close()
+
+	jwt, err := verifier.VerifyAccessToken(signToken(t, firstKey, jwa.RS256, nil, validClaims(first)))
+	require.NoError(t, err)
+	require.Equal(t, first, jwt.Claims["iss"])
+
+	secondClaims := validClaims(second)
+	secondClaims["aud"] = "api://second"
+	_, err = verifier.VerifyAccessToken(signToken(t, secondKey, jwa.RS256, nil, secondClaims))
+	require.NoError(t, err)
+
+	// each issuer keeps its own audience rules
+	_, err = verifier.VerifyAccessToken(signToken(t, secondKey, jwa.RS256, nil, validClaims(second)))
+	require.ErrorIs(t, err, oktaErrors.ErrAudienceMismatch)
+
+	// a token signed by one issuer's key cannot claim to be from the other
+	_, err = verifier.VerifyAccessToken(signToken(t, firstKey, jwa.RS256, nil, secondClaims))
+	require.ErrorIs(t, err, oktaErrors.ErrKeyNotFound)
+
+	before := httpmock.GetTotalCallCount()
+	_, err = verifier.VerifyAccessToken(signToken(t, unknownKey, jwa.RS256, nil, validClaims(unknown)))
+	require.ErrorIs(t, err, oktaErrors.ErrIssuerMismatch)
+	require.Equal(t, before, httpmock.GetTotalCallCount(), "unknown issuers must not be contacted")
+
+	_, err = verifier.VerifyAccessToken("not-a-token")
+	require.ErrorIs(t, err, oktaErrors.ErrMalformedToken)
+}
+
+func TestMultiIssuerVerifierRejectsDuplicateIssuers(t *testing.T) {
+	mv := MultiIssuerVerifier{Verifiers: []*JwtVerifier{
+		{Issuer: "https://example.com/oauth2/default"},
+		{Issuer: "https://example.com/oauth2/default/"},
+	}}
+	_, err := mv.New()
+	require.ErrorContains(t, err, "configured more than once")
+}
+
+func TestMultiIssuerVerifierEncryptedIdToken(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	first := "https://first.example.com/oauth2/default"
+	second := "https://second.example.com/oauth2/ausabc"
+	firstKey := newSigningKey(t, "first", jwa.RS256)
+	secondKey := newSigningKey(t, "second", jwa.RS256)
+	mockIssuer(t, first, firstKey)
+	mockIssuer(t, second, secondKey)
+
+	firstDecryptionKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	secondDecryptionKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	mv := MultiIssuerVerifier{Verifiers: []*JwtVerifier{
+		{Issuer: first, DecryptionKeys: []crypto.PrivateKey{firstDecryptionKey}},
+		{Issuer: second, DecryptionKeys: []crypto.PrivateKey{secondDecryptionKey}},
+	}}
+	verifier, err := mv.New()
+	require.NoError(t, err)
+	defer verifier.Close()
+
+	idToken := signToken(t, secondKey, jwa.RS256, nil, validClaims(second))
+	jwt, err := verifier.VerifyIdToken(encryptToken(t, idToken, jwa.RSA_OAEP_256, jwa.A256GCM, &secondDecryptionKey.PublicKey))
+	require.NoError(t, err)
+	require.Equal(t, second, jwt.Claims["iss"])
+
+	// the nested token is routed by its own issuer, not by the key that
+	// decrypted it
+	_, err = verifier.VerifyIdToken(encryptToken(t, idToken, jwa.RSA_OAEP_256, jwa.A256GCM, &firstDecryptionKey.PublicKey))
+	require.NoError(t, err)
+
+	_, err = verifier.VerifyIdToken(encryptToken(t, idToken, jwa.RSA_OAEP_256, jwa.A256GCM, &otherKey.PublicKey))
+	require.ErrorIs(t, err, oktaErrors.ErrDecryptionFailed)
+	require.ErrorIs(t, err, oktaErrors.ErrInvalidToken)
+
+	noKeys, err := (&MultiIssuerVerifier{Verifiers: []*JwtVerifier{{Issuer: first}}}).New()
+	require.NoError(t, err)
+	defer noKeys.Close()
+	_, err = noKeys.VerifyIdToken(encryptToken(t, idToken, jwa.RSA_OAEP_256, jwa.A256GCM, &firstDecryptionKey.PublicKey))
+	require.ErrorIs(t, err, oktaErrors.ErrDecryptionFailed)
+}
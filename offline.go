@@ -0,0 +1,98 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package jwtverifier
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/adaptors/lestrratGoJwx"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+)
+
+// loadOfflineResources reads MetadataFile and KeySetFile, if set, so that
+// New fails early on a missing or invalid file. It returns the key set read
+// from KeySetFile, or nil when keys are fetched from the jwks_uri.
+func (j *JwtVerifier) loadOfflineResources() (jwk.Set, []byte, error) {
+	if j.MetadataFile != "" {
+		data, err := j.readFile(j.MetadataFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not read metadata file: %w", err)
+		}
+		metadata, err := decodeProviderMetadata(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not decode metadata file %q: %w", j.MetadataFile, err)
+		}
+		if err := j.validateMetadata(metadata); err != nil {
+			return nil, nil, fmt.Errorf("metadata file %q is not valid: %w", j.MetadataFile, err)
+		}
+		j.staticMetadata = metadata
+	}
+
+	if j.KeySetFile == "" {
+		return nil, nil, nil
+	}
+	if j.Adaptor != nil {
+		return nil, nil, fmt.Errorf("KeySetFile cannot be used with a custom Adaptor")
+	}
+	data, err := j.readFile(j.KeySetFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read key set file: %w", err)
+	}
+	set, err := lestrratGoJwx.ParseKeySet(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse key set file %q: %w", j.KeySetFile, err)
+	}
+	return set, data, nil
+}
+
+func (j *JwtVerifier) readFile(name string) ([]byte, error) {
+	if j.FS != nil {
+		return fs.ReadFile(j.FS, name)
+	}
+	return os.ReadFile(name)
+}
+
+// watchKeySet re-reads KeySetFile every KeySetReloadInterval until Close is
+// called, and hands its keys to adaptor whenever the file changed. A file
+// that cannot be read or parsed, for instance because it is being written,
+// is tried again on the next tick; the previous keys stay in use meanwhile.
+func (j *JwtVerifier) watchKeySet(adaptor *lestrratGoJwx.LestrratGoJwx, last []byte) {
+	ticker := time.NewTicker(j.KeySetReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.stopWatch:
+			return
+		case <-ticker.C:
+		}
+
+		data, err := j.readFile(j.KeySetFile)
+		if err != nil || bytes.Equal(data, last) {
+			continue
+		}
+		set, err := lestrratGoJwx.ParseKeySet(data)
+		if err != nil {
+			continue
+		}
+		adaptor.SetJWKSet(set)
+		last = data
+	}
+}
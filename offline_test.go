@@ -0,0 +1,180 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package jwtverifier
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	oktaErrors "github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+	"github.com/jarcoal/httpmock"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/stretchr/testify/require"
+)
+
+func marshalKeySet(t *testing.T, keys ...jwk.Key) []byte {
+	t.Helper()
+
+	set := jwk.NewSet()
+	for _, key := range keys {
+		pub, err := jwk.PublicKeyOf(key)
+		require.NoError(t, err)
+		require.NoError(t, set.AddKey(pub))
+	}
+	data, err := json.Marshal(set)
+	require.NoError(t, err)
+	return data
+}
+
+func TestOfflineKeySetFile(t *testing.T) {
+	// no responders are registered, so any request fails
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	key := newSigningKey(t, "key", jwa.RS256)
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "keys.json"), marshalKeySet(t, key), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "metadata.json"),
+		[]byte(`{"issuer":"`+issuer+`","introspection_endpoint":"`+issuer+`/v1/introspect"}`), 0o600))
+
+	verifier, err := (&JwtVerifier{
+		Issuer:       issuer,
+		KeySetFile:   filepath.Join(dir, "keys.json"),
+		MetadataFile: filepath.Join(dir, "metadata.json"),
+	}).New()
+	require.NoError(t, err)
+	defer verifier.Close()
+
+	_, err = verifier.VerifyAccessToken(signToken(t, key, jwa.RS256, nil, validClaims(issuer)))
+	require.NoError(t, err)
+
+	_, err = verifier.VerifyAccessToken(signToken(t, newSigningKey(t, "other", jwa.RS256), jwa.RS256, nil, validClaims(issuer)))
+	require.ErrorIs(t, err, oktaErrors.ErrKeyNotFound)
+
+	metadata, err := verifier.Metadata(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, issuer+"/v1/introspect", metadata.IntrospectionEndpoint)
+	require.Zero(t, httpmock.GetTotalCallCount())
+}
+
+func TestOfflinePEMBundleFromFS(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+
+	certKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "signing"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &certKey.PublicKey, certKey)
+	require.NoError(t, err)
+
+	namedKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	publicKey, err := x509.MarshalPKIXPublicKey(&namedKey.PublicKey)
+	require.NoError(t, err)
+
+	bundle := append(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Headers: map[string]string{"kid": "named"}, Bytes: publicKey})...)
+
+	verifier, err := (&JwtVerifier{
+		Issuer:     issuer,
+		KeySetFile: "keys/bundle.pem",
+		FS:         fstest.MapFS{"keys/bundle.pem": {Data: bundle}},
+	}).New()
+	require.NoError(t, err)
+	defer verifier.Close()
+
+	signWith := func(raw interface{}, kid string) string {
+		key, err := jwk.FromRaw(raw)
+		require.NoError(t, err)
+		require.NoError(t, key.Set(jwk.KeyIDKey, kid))
+		return signToken(t, key, jwa.RS256, nil, validClaims(issuer))
+	}
+
+	_, err = verifier.VerifyAccessToken(signWith(namedKey, "named"))
+	require.NoError(t, err)
+
+	// the certificate has no kid, so it is tried for any kid not in the bundle
+	_, err = verifier.VerifyAccessToken(signWith(certKey, "rotated"))
+	require.NoError(t, err)
+
+	_, err = verifier.VerifyAccessToken(signWith(namedKey, "rotated"))
+	require.ErrorIs(t, err, oktaErrors.ErrInvalidSignature)
+	require.Zero(t, httpmock.GetTotalCallCount())
+}
+
+func TestOfflineKeySetFileErrors(t *testing.T) {
+	files := fstest.MapFS{"keys.pem": {Data: []byte("not a key")}}
+
+	_, err := (&JwtVerifier{Issuer: "https://example.com", KeySetFile: "missing.json", FS: files}).New()
+	require.Error(t, err)
+
+	_, err = (&JwtVerifier{Issuer: "https://example.com", KeySetFile: "keys.pem", FS: files}).New()
+	require.Error(t, err)
+}
+
+func TestOfflineKeySetIsReloaded(t *testing.T) {
+	issuer := "https://example.com/oauth2/default"
+	oldKey := newSigningKey(t, "old", jwa.RS256)
+	newKey := newSigningKey(t, "new", jwa.RS256)
+	file := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(file, marshalKeySet(t, oldKey), 0o600))
+
+	verifier, err := (&JwtVerifier{
+		Issuer:               issuer,
+		KeySetFile:           file,
+		KeySetReloadInterval: 10 * time.Millisecond,
+	}).New()
+	require.NoError(t, err)
+	defer verifier.Close()
+
+	token := signToken(t, newKey, jwa.RS256, nil, validClaims(issuer))
+	_, err = verifier.VerifyAccessToken(token)
+	require.ErrorIs(t, err, oktaErrors.ErrKeyNotFound)
+
+	require.NoError(t, os.WriteFile(file, marshalKeySet(t, newKey), 0o600))
+	require.Eventually(t, func() bool {
+		_, err := verifier.VerifyAccessToken(token)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// a broken file does not replace the keys in use
+	require.NoError(t, os.WriteFile(file, []byte("{"), 0o600))
+	time.Sleep(50 * time.Millisecond)
+	_, err = verifier.VerifyAccessToken(token)
+	require.NoError(t, err)
+}
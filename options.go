@@ -0,0 +1,160 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package jwtverifier
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+)
+
+// VerifyOption adds requirements to a single verification.
+type VerifyOption func(*verifyOptions)
+
+type verifyOptions struct {
+	allScopes []string
+	anyScopes []string
+
+	// certificateBound is set by WithClientCertificate and
+	// WithTLSConnectionState; certificate is nil when the client presented
+	// none.
+	certificateBound bool
+	certificate      *x509.Certificate
+
+	tokenHashes []tokenHashBinding
+
+	maxAge      time.Duration
+	acrValues   []string
+	requiredAmr []string
+}
+
+func newVerifyOptions(opts []VerifyOption) *verifyOptions {
+	options := &verifyOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// WithRequiredScopes requires the access token to grant every one of scopes.
+func WithRequiredScopes(scopes ...string) VerifyOption {
+	return func(o *verifyOptions) {
+		o.allScopes = append(o.allScopes, scopes...)
+	}
+}
+
+// WithAnyScope requires the access token to grant at least one of scopes.
+func WithAnyScope(scopes ...string) VerifyOption {
+	return func(o *verifyOptions) {
+		o.anyScopes = append(o.anyScopes, scopes...)
+	}
+}
+
+// ValidateOptions checks the claims of an already verified token against the
+// requirements in opts. It lets Verifier implementations other than
+// JwtVerifier honour VerifyOption. The claims do not tell which algorithm
+// the token was signed with, so WithAccessToken and WithAuthorizationCode
+// make it return an error saying at_hash and c_hash cannot be checked.
+// The default leeway of two minutes applies to WithMaxAge.
+func ValidateOptions(token *Jwt, opts ...VerifyOption) error {
+	return newVerifyOptions(opts).validate(token.Claims, "", defaultLeeway)
+}
+
+// validate checks claims against the options. alg is the algorithm the token
+// was signed with, and leeway the allowed clock skew in seconds.
+func (o *verifyOptions) validate(claims map[string]interface{}, alg string, leeway int64) error {
+	err := o.validateTokenHashes(claims, alg)
+	if err != nil {
+		return fmt.Errorf("the `Token Hash` was not able to be validated. %w", err)
+	}
+
+	err = o.validateCertificateBinding(claims)
+	if err != nil {
+		return fmt.Errorf("the `Confirmation` was not able to be validated. %w", err)
+	}
+
+	err = o.validateAuthentication(claims, leeway)
+	if err != nil {
+		return fmt.Errorf("the `Authentication` was not able to be validated. %w", err)
+	}
+
+	err = o.validateScopes(claims)
+	if err != nil {
+		return fmt.Errorf("the `Scope` was not able to be validated. %w", err)
+	}
+	return nil
+}
+
+func (o *verifyOptions) validateScopes(claims map[string]interface{}) error {
+	if len(o.allScopes) == 0 && len(o.anyScopes) == 0 {
+		return nil
+	}
+
+	granted := map[string]bool{}
+	for _, scope := range scopes(claims) {
+		granted[scope] = true
+	}
+
+	var missing []string
+	for _, scope := range o.allScopes {
+		if !granted[scope] {
+			missing = append(missing, scope)
+		}
+	}
+	if len(missing) > 0 {
+		return &errors.InsufficientScope{Missing: missing}
+	}
+
+	if len(o.anyScopes) == 0 {
+		return nil
+	}
+	for _, scope := range o.anyScopes {
+		if granted[scope] {
+			return nil
+		}
+	}
+	return &errors.InsufficientScope{Missing: o.anyScopes, AnyOf: true}
+}
+
+// Scopes returns the scopes granted by the token, read from Okta's scp array
+// or the space separated scope claim of RFC 9068.
+func (j *Jwt) Scopes() []string {
+	return scopes(j.Claims)
+}
+
+func scopes(claims map[string]interface{}) []string {
+	var scopes []string
+	switch scp := claims["scp"].(type) {
+	case []interface{}:
+		for _, s := range scp {
+			if scope, ok := s.(string); ok {
+				scopes = append(scopes, scope)
+			}
+		}
+	case []string:
+		scopes = append(scopes, scp...)
+	case string:
+		scopes = append(scopes, strings.Fields(scp)...)
+	}
+	if scope, ok := claims["scope"].(string); ok {
+		scopes = append(scopes, strings.Fields(scope)...)
+	}
+	return scopes
+}
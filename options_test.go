@@ -0,0 +1,70 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package jwtverifier
+
+import (
+	"errors"
+	"testing"
+
+	oktaErrors "github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+	"github.com/jarcoal/httpmock"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequiredScopes(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	key := newSigningKey(t, "rsa", jwa.RS256)
+	mockIssuer(t, issuer, key)
+
+	claims := validClaims(issuer)
+	claims["scp"] = []string{"openid", "profile"}
+	token := signToken(t, key, jwa.RS256, nil, claims)
+
+	jvs := JwtVerifier{Issuer: issuer}
+	jv, err := jvs.New()
+	require.NoError(t, err)
+
+	jwt, err := jv.VerifyAccessToken(token, WithRequiredScopes("openid", "profile"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"openid", "profile"}, jwt.Scopes())
+
+	_, err = jv.VerifyAccessToken(token, WithAnyScope("admin", "profile"))
+	require.NoError(t, err)
+
+	_, err = jv.VerifyAccessToken(token, WithRequiredScopes("openid", "email", "groups"))
+	require.ErrorIs(t, err, oktaErrors.ErrInsufficientScope)
+	var insufficient *oktaErrors.InsufficientScope
+	require.True(t, errors.As(err, &insufficient))
+	require.Equal(t, []string{"email", "groups"}, insufficient.Missing)
+	require.False(t, insufficient.AnyOf)
+
+	_, err = jv.VerifyAccessToken(token, WithAnyScope("admin", "superuser"))
+	require.True(t, errors.As(err, &insufficient))
+	require.Equal(t, []string{"admin", "superuser"}, insufficient.Missing)
+	require.True(t, insufficient.AnyOf)
+}
+
+func TestScopesReadsScopeClaim(t *testing.T) {
+	jwt := Jwt{Claims: map[string]interface{}{"scope": "read write"}}
+	require.Equal(t, []string{"read", "write"}, jwt.Scopes())
+	require.NoError(t, ValidateOptions(&jwt, WithRequiredScopes("write")))
+	require.ErrorIs(t, ValidateOptions(&jwt, WithRequiredScopes("admin")), oktaErrors.ErrInsufficientScope)
+}
@@ -0,0 +1,88 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package jwtverifier
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+	"github.com/patrickmn/go-cache"
+)
+
+// ReplayStore remembers the jti of tokens that have already been accepted.
+//
+// Store records jti until the given time and reports whether it was
+// recorded, returning false when jti was already present. It must be atomic,
+// so that two concurrent calls with the same jti cannot both return true.
+type ReplayStore interface {
+	Store(ctx context.Context, jti string, until time.Time) (bool, error)
+}
+
+// MemoryReplayStore is a ReplayStore that keeps the jti values in memory. It
+// only protects a single process.
+type MemoryReplayStore struct {
+	// Cleanup is how often expired jti values are removed. It defaults to
+	// ten minutes.
+	Cleanup time.Duration
+
+	seen *cache.Cache
+}
+
+func (s *MemoryReplayStore) New() (*MemoryReplayStore, error) {
+	if s.Cleanup == 0 {
+		s.Cleanup = 10 * time.Minute
+	}
+	s.seen = cache.New(cache.NoExpiration, s.Cleanup)
+	return s, nil
+}
+
+func (s *MemoryReplayStore) Store(ctx context.Context, jti string, until time.Time) (bool, error) {
+	ttl := time.Until(until)
+	if ttl <= 0 {
+		// the token can no longer be accepted, so there is nothing to record
+		return true, nil
+	}
+	return s.seen.Add(jti, struct{}{}, ttl) == nil, nil
+}
+
+// checkReplay records the jti of token in the ReplayStore, rejecting the
+// token if it was seen before.
+func (j *JwtVerifier) checkReplay(ctx context.Context, token map[string]interface{}) error {
+	if !j.ReplayProtection {
+		return nil
+	}
+	jti := claimString(token["jti"])
+	if jti == "" {
+		return errors.Mark(fmt.Errorf("jti: missing"), errors.ErrMissingClaim)
+	}
+	exp, ok := token["exp"].(float64)
+	if !ok {
+		return errors.Mark(fmt.Errorf("exp: missing"), errors.ErrMissingClaim)
+	}
+
+	until := time.Unix(int64(exp)+j.leeway, 0)
+	stored, err := j.ReplayStore.Store(ctx, j.Issuer+"#"+jti, until)
+	if err != nil {
+		return errors.Mark(fmt.Errorf("could not record jti: %w", err), errors.ErrReplayStoreUnavailable)
+	}
+	if !stored {
+		return errors.Mark(fmt.Errorf("jti: %s has already been used", jti), errors.ErrTokenReplayed)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package jwtverifier
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	oktaErrors "github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+	"github.com/jarcoal/httpmock"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayProtection(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	key := newSigningKey(t, "key", jwa.RS256)
+	mockIssuer(t, issuer, key)
+
+	verifier, err := (&JwtVerifier{Issuer: issuer, ReplayProtection: true}).New()
+	require.NoError(t, err)
+
+	claims := validClaims(issuer)
+	claims["jti"] = "one-time"
+	token := signToken(t, key, jwa.RS256, nil, claims)
+
+	_, err = verifier.VerifyAccessToken(token)
+	require.NoError(t, err)
+	_, err = verifier.VerifyAccessToken(token)
+	require.ErrorIs(t, err, oktaErrors.ErrTokenReplayed)
+	require.ErrorIs(t, err, oktaErrors.ErrInvalidToken)
+
+	_, err = verifier.VerifyAccessToken(signToken(t, key, jwa.RS256, nil, validClaims(issuer)))
+	require.ErrorIs(t, err, oktaErrors.ErrMissingClaim)
+
+	// a token rejected for another reason does not use up its jti
+	claims["jti"] = "scoped"
+	token = signToken(t, key, jwa.RS256, nil, claims)
+	_, err = verifier.VerifyAccessToken(token, WithRequiredScopes("admin"))
+	require.ErrorIs(t, err, oktaErrors.ErrInsufficientScope)
+	_, err = verifier.VerifyAccessToken(token)
+	require.NoError(t, err)
+}
+
+func TestReplayProtectionIsOffByDefault(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	key := newSigningKey(t, "key", jwa.RS256)
+	mockIssuer(t, issuer, key)
+
+	verifier, err := (&JwtVerifier{Issuer: issuer}).New()
+	require.NoError(t, err)
+
+	token := signToken(t, key, jwa.RS256, nil, validClaims(issuer))
+	for i := 0; i < 2; i++ {
+		_, err = verifier.VerifyAccessToken(token)
+		require.NoError(t, err)
+	}
+}
+
+func TestMemoryReplayStoreIsAtomic(t *testing.T) {
+	store, err := (&MemoryReplayStore{}).New()
+	require.NoError(t, err)
+
+	var stored int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := store.Store(context.Background(), "jti", time.Now().Add(time.Minute))
+			require.NoError(t, err)
+			if ok {
+				atomic.AddInt32(&stored, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), stored)
+}
@@ -0,0 +1,146 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package jwtverifier
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/utils"
+)
+
+// TokenRef identifies a verified token to a RevocationChecker. Fields the
+// token does not carry are empty.
+type TokenRef struct {
+	Jti      string
+	Sub      string
+	Sid      string
+	IssuedAt time.Time
+}
+
+// RevocationChecker reports whether a token has been revoked. It is
+// consulted after the signature and claims of a token have been validated.
+type RevocationChecker interface {
+	Revoked(ctx context.Context, ref TokenRef) (bool, error)
+}
+
+// RevocationList is a RevocationChecker backed by a utils.KeyValueStore.
+// Entries expire on their own once the tokens they revoke would have
+// expired anyway.
+type RevocationList struct {
+	// Store holds the revocations. It defaults to an in-memory store; use a
+	// shared store such as Redis when several processes verify tokens.
+	Store utils.KeyValueStore
+
+	// Prefix is prepended to every key written to Store.
+	Prefix string
+}
+
+func (r *RevocationList) New() (*RevocationList, error) {
+	if r.Store == nil {
+		r.Store = utils.NewMemoryStore(10 * time.Minute)
+	}
+	return r, nil
+}
+
+// RevokeToken revokes the token with the given jti until it expires at exp.
+func (r *RevocationList) RevokeToken(ctx context.Context, jti string, exp time.Time) error {
+	return r.Store.Set(ctx, r.Prefix+"jti:"+jti, []byte("1"), time.Until(exp))
+}
+
+// RevokeSubject revokes every token issued to sub up to now. until should be
+// no earlier than the expiry of the longest lived of those tokens.
+func (r *RevocationList) RevokeSubject(ctx context.Context, sub string, until time.Time) error {
+	return r.revokeBefore(ctx, "sub:"+sub, until)
+}
+
+// RevokeSession revokes every token of the session sid issued up to now.
+// until should be no earlier than the expiry of the longest lived of those
+// tokens.
+func (r *RevocationList) RevokeSession(ctx context.Context, sid string, until time.Time) error {
+	return r.revokeBefore(ctx, "sid:"+sid, until)
+}
+
+func (r *RevocationList) revokeBefore(ctx context.Context, key string, until time.Time) error {
+	revokedAt := strconv.FormatInt(time.Now().Unix(), 10)
+	return r.Store.Set(ctx, r.Prefix+key, []byte(revokedAt), time.Until(until))
+}
+
+func (r *RevocationList) Revoked(ctx context.Context, ref TokenRef) (bool, error) {
+	if ref.Jti != "" {
+		_, found, err := r.Store.Get(ctx, r.Prefix+"jti:"+ref.Jti)
+		if err != nil || found {
+			return found, err
+		}
+	}
+	if ref.Sub != "" {
+		revoked, err := r.revokedBefore(ctx, "sub:"+ref.Sub, ref.IssuedAt)
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+	if ref.Sid != "" {
+		return r.revokedBefore(ctx, "sid:"+ref.Sid, ref.IssuedAt)
+	}
+	return false, nil
+}
+
+// revokedBefore reports whether key was revoked at or after issuedAt. Tokens
+// without an issue time are treated as issued before any revocation.
+func (r *RevocationList) revokedBefore(ctx context.Context, key string, issuedAt time.Time) (bool, error) {
+	value, found, err := r.Store.Get(ctx, r.Prefix+key)
+	if err != nil || !found {
+		return false, err
+	}
+	revokedAt, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("invalid revocation entry for %s: %w", key, err)
+	}
+	return issuedAt.IsZero() || issuedAt.Unix() <= revokedAt, nil
+}
+
+// checkRevocation consults the RevocationChecker, if any, about token.
+func (j *JwtVerifier) checkRevocation(ctx context.Context, token map[string]interface{}) error {
+	if j.RevocationChecker == nil {
+		return nil
+	}
+	ref := TokenRef{
+		Jti: claimString(token["jti"]),
+		Sub: claimString(token["sub"]),
+		Sid: claimString(token["sid"]),
+	}
+	if iat, ok := token["iat"].(float64); ok {
+		ref.IssuedAt = time.Unix(int64(iat), 0)
+	}
+
+	revoked, err := j.RevocationChecker.Revoked(ctx, ref)
+	if err != nil {
+		return errors.Mark(fmt.Errorf("could not check revocation: %w", err), errors.ErrRevocationUnavailable)
+	}
+	if revoked {
+		return errors.Mark(fmt.Errorf("the token has been revoked"), errors.ErrTokenRevoked)
+	}
+	return nil
+}
+
+func claimString(claim interface{}) string {
+	s, _ := claim.(string)
+	return s
+}
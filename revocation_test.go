@@ -0,0 +1,127 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package jwtverifier
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	oktaErrors "github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+	"github.com/jarcoal/httpmock"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevocationChecker(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	key := newSigningKey(t, "key", jwa.RS256)
+	mockIssuer(t, issuer, key)
+
+	ctx := context.Background()
+	revocations, err := (&RevocationList{}).New()
+	require.NoError(t, err)
+
+	jv := JwtVerifier{
+		Issuer:            issuer,
+		RevocationChecker: revocations,
+	}
+	verifier, err := jv.New()
+	require.NoError(t, err)
+
+	tokenWith := func(extra map[string]interface{}) string {
+		claims := validClaims(issuer)
+		for k, v := range extra {
+			claims[k] = v
+		}
+		return signToken(t, key, jwa.RS256, nil, claims)
+	}
+
+	first := tokenWith(map[string]interface{}{"jti": "first", "sid": "session"})
+	second := tokenWith(map[string]interface{}{"jti": "second", "sid": "session"})
+	_, err = verifier.VerifyAccessToken(first)
+	require.NoError(t, err)
+
+	require.NoError(t, revocations.RevokeToken(ctx, "first", time.Now().Add(time.Hour)))
+	_, err = verifier.VerifyAccessToken(first)
+	require.ErrorIs(t, err, oktaErrors.ErrTokenRevoked)
+	require.ErrorIs(t, err, oktaErrors.ErrInvalidToken)
+	_, err = verifier.VerifyAccessToken(second)
+	require.NoError(t, err)
+
+	require.NoError(t, revocations.RevokeSession(ctx, "session", time.Now().Add(time.Hour)))
+	_, err = verifier.VerifyAccessToken(second)
+	require.ErrorIs(t, err, oktaErrors.ErrTokenRevoked)
+
+	// tokens issued after the session or subject was revoked are accepted
+	require.NoError(t, revocations.RevokeSubject(ctx, "user@example.com", time.Now().Add(time.Hour)))
+	_, err = verifier.VerifyIdToken(tokenWith(nil))
+	require.ErrorIs(t, err, oktaErrors.ErrTokenRevoked)
+	_, err = verifier.VerifyAccessToken(tokenWith(map[string]interface{}{"iat": time.Now().Unix() + 60}))
+	require.NoError(t, err)
+}
+
+func TestRevocationListEntriesExpire(t *testing.T) {
+	ctx := context.Background()
+	revocations, err := (&RevocationList{}).New()
+	require.NoError(t, err)
+
+	require.NoError(t, revocations.RevokeToken(ctx, "jti", time.Now().Add(50*time.Millisecond)))
+	revoked, err := revocations.Revoked(ctx, TokenRef{Jti: "jti"})
+	require.NoError(t, err)
+	require.True(t, revoked)
+
+	time.Sleep(100 * time.Millisecond)
+	revoked, err = revocations.Revoked(ctx, TokenRef{Jti: "jti"})
+	require.NoError(t, err)
+	require.False(t, revoked)
+}
+
+type failingRevocationChecker struct{}
+
+func (failingRevocationChecker) Revoked(ctx context.Context, ref TokenRef) (bool, error) {
+	return false, errors.New("store is down")
+}
+
+func TestRevocationCheckerFailureIsUnavailable(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	key := newSigningKey(t, "key", jwa.RS256)
+	mockIssuer(t, issuer, key)
+
+	verifier, err := (&JwtVerifier{Issuer: issuer, RevocationChecker: failingRevocationChecker{}}).New()
+	require.NoError(t, err)
+
+	_, err = verifier.VerifyAccessToken(signToken(t, key, jwa.RS256, nil, validClaims(issuer)))
+	require.ErrorIs(t, err, oktaErrors.ErrRevocationUnavailable)
+	require.ErrorIs(t, err, oktaErrors.ErrUnavailable)
+
+	// tokens failing a local check are rejected without asking the store
+	_, err = verifier.VerifyAccessToken(signToken(t, key, jwa.RS256, nil, validClaims(issuer)), WithRequiredScopes("admin"))
+	require.ErrorIs(t, err, oktaErrors.ErrInsufficientScope)
+
+	idClaims := validClaims(issuer)
+	idClaims["nonce"] = "other"
+	_, err = verifier.VerifyIdToken(signToken(t, key, jwa.RS256, nil, idClaims))
+	require.ErrorIs(t, err, oktaErrors.ErrNonceMismatch)
+}
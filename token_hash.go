@@ -0,0 +1,98 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package jwtverifier
+
+import (
+	"crypto"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+)
+
+// tokenHashBinding is a value an ID token must carry the hash of in claim.
+type tokenHashBinding struct {
+	claim string
+	value string
+}
+
+// WithAccessToken requires the ID token to be bound to accessToken, the
+// access token issued along with it, through its at_hash claim.
+func WithAccessToken(accessToken string) VerifyOption {
+	return func(o *verifyOptions) {
+		o.tokenHashes = append(o.tokenHashes, tokenHashBinding{claim: "at_hash", value: accessToken})
+	}
+}
+
+// WithAuthorizationCode requires the ID token to be bound to code, the
+// authorization code issued along with it, through its c_hash claim.
+func WithAuthorizationCode(code string) VerifyOption {
+	return func(o *verifyOptions) {
+		o.tokenHashes = append(o.tokenHashes, tokenHashBinding{claim: "c_hash", value: code})
+	}
+}
+
+// validateTokenHashes checks at_hash and c_hash as described in OIDC Core
+// sections 3.2.2.9 and 3.3.2.11. alg is the algorithm the ID token was
+// signed with.
+func (o *verifyOptions) validateTokenHashes(claims map[string]interface{}, alg string) error {
+	if alg == "" && len(o.tokenHashes) > 0 {
+		// not the token's fault, so this is not an ErrInvalidToken
+		return fmt.Errorf("at_hash and c_hash cannot be checked without the signing algorithm of the token")
+	}
+	for _, binding := range o.tokenHashes {
+		expected, ok := claims[binding.claim].(string)
+		if !ok || expected == "" {
+			return errors.Mark(fmt.Errorf("%s: missing", binding.claim), errors.ErrMissingClaim)
+		}
+		actual, err := tokenHash(binding.value, alg)
+		if err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) != 1 {
+			return errors.Mark(fmt.Errorf("%s: %s does not match %s", binding.claim, expected, actual), errors.ErrTokenHashMismatch)
+		}
+	}
+	return nil
+}
+
+// tokenHash returns the base64url encoding of the left half of the hash of
+// value, using the hash function of the JWS algorithm alg.
+func tokenHash(value, alg string) (string, error) {
+	var hash crypto.Hash
+	switch {
+	case alg == "EdDSA":
+		// Ed25519 signs with SHA-512
+		hash = crypto.SHA512
+	case strings.HasSuffix(alg, "256"):
+		hash = crypto.SHA256
+	case strings.HasSuffix(alg, "384"):
+		hash = crypto.SHA384
+	case strings.HasSuffix(alg, "512"):
+		hash = crypto.SHA512
+	default:
+		return "", errors.Mark(fmt.Errorf("no hash function is known for alg %q", alg), errors.ErrUnsupportedAlg)
+	}
+	h := hash.New()
+	h.Write([]byte(value))
+	sum := h.Sum(nil)
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]), nil
+}
@@ -0,0 +1,94 @@
+/*******************************************************************************
+ * Copyright 2018 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ ******************************************************************************/
+
+package jwtverifier
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"testing"
+
+	oktaErrors "github.com/hung12ct/okta-jwt-verifier-golang/v2/errors"
+	"github.com/jarcoal/httpmock"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenHash(t *testing.T) {
+	// c_hash example from OpenID Connect Core 1.0, appendix A.4
+	hash, err := tokenHash("Qcb0Orv1zh30vL1MPRsbm-diHiMwcLyZvn1arpZv-Jxf_11jnpEX3Tgfvk", "RS256")
+	require.NoError(t, err)
+	require.Equal(t, "LDktKdoQak3Pk0cnXxCltA", hash)
+
+	sum := sha512.Sum384([]byte("access-token"))
+	hash, err = tokenHash("access-token", "ES384")
+	require.NoError(t, err)
+	require.Equal(t, base64.RawURLEncoding.EncodeToString(sum[:24]), hash)
+
+	_, err = tokenHash("access-token", "none")
+	require.ErrorIs(t, err, oktaErrors.ErrUnsupportedAlg)
+
+	// ValidateOptions does not know the algorithm, which is not the token's fault
+	jwt := Jwt{Claims: map[string]interface{}{"at_hash": hash}}
+	err = ValidateOptions(&jwt, WithAccessToken("access-token"))
+	require.ErrorContains(t, err, "cannot be checked without the signing algorithm")
+	require.NotErrorIs(t, err, oktaErrors.ErrInvalidToken)
+}
+
+func TestIdTokenHashes(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	issuer := "https://example.com/oauth2/default"
+	key := newSigningKey(t, "key", jwa.PS512)
+	mockIssuer(t, issuer, key)
+
+	verifier, err := (&JwtVerifier{
+		Issuer:            issuer,
+		ClaimsToValidate:  map[string]string{"nonce": "nonce"},
+		SigningAlgorithms: []string{"PS512"},
+	}).New()
+	require.NoError(t, err)
+
+	atHash, err := tokenHash("access-token", "PS512")
+	require.NoError(t, err)
+	cHash, err := tokenHash("code", "PS512")
+	require.NoError(t, err)
+
+	claims := validClaims(issuer)
+	claims["nonce"] = "nonce"
+	claims["at_hash"] = atHash
+	claims["c_hash"] = cHash
+	idToken := signToken(t, key, jwa.PS512, nil, claims)
+
+	_, err = verifier.VerifyIdToken(idToken, WithAccessToken("access-token"), WithAuthorizationCode("code"))
+	require.NoError(t, err)
+
+	_, err = verifier.VerifyIdToken(idToken, WithAccessToken("other-access-token"))
+	require.ErrorIs(t, err, oktaErrors.ErrTokenHashMismatch)
+	require.ErrorIs(t, err, oktaErrors.ErrInvalidToken)
+
+	_, err = verifier.VerifyIdToken(idToken, WithAuthorizationCode("other-code"))
+	require.ErrorIs(t, err, oktaErrors.ErrTokenHashMismatch)
+
+	delete(claims, "c_hash")
+	_, err = verifier.VerifyIdToken(signToken(t, key, jwa.PS512, nil, claims), WithAuthorizationCode("code"))
+	require.ErrorIs(t, err, oktaErrors.ErrMissingClaim)
+
+	// the hashes are only checked when asked for
+	_, err = verifier.VerifyIdToken(idToken)
+	require.NoError(t, err)
+}
@@ -1,10 +1,11 @@
 package utils
 
 import (
+	"container/list"
+	"context"
+	"errors"
 	"sync"
 	"time"
-
-	"github.com/patrickmn/go-cache"
 )
 
 // Cacher is a read-only cache interface.
@@ -14,40 +15,252 @@ type Cacher interface {
 	Get(string) (interface{}, error)
 }
 
+// ContextCacher is a Cacher whose lookups honour a context.
+//
+// GetContext returns the value associated with the given key, giving up when
+// ctx is done.
+type ContextCacher interface {
+	Cacher
+	GetContext(context.Context, string) (interface{}, error)
+}
+
+// Refresher is implemented by caches that can replace a cached value with a
+// fresh lookup on demand.
+type Refresher interface {
+	Refresh(context.Context, string) (interface{}, error)
+}
+
+// ContextLookup resolves the value for a key on a cache miss.
+type ContextLookup func(context.Context, string) (interface{}, error)
+
+// GetContext returns the value for key from c, passing ctx along when c is a
+// ContextCacher.
+func GetContext(ctx context.Context, c Cacher, key string) (interface{}, error) {
+	if cc, ok := c.(ContextCacher); ok {
+		return cc.GetContext(ctx, key)
+	}
+	return c.Get(key)
+}
+
+// DefaultCacheMaxEntries is the number of entries NewDefaultCache and
+// NewDefaultContextCache keep before evicting the least recently used one.
+const DefaultCacheMaxEntries = 1000
+
+// errLookupPanicked is handed to the callers waiting on a lookup that
+// panicked. The panic itself goes up the stack of the caller that ran it.
+var errLookupPanicked = errors.New("cache lookup panicked")
+
+type cacheEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// lookupCall is a lookup in progress. Callers asking for the same key wait
+// for it instead of starting their own.
+type lookupCall struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+	// cancelled is set when the lookup failed because the context of the
+	// caller running it was done; the callers waiting on it try again.
+	cancelled bool
+}
+
+// lookupGroup coalesces concurrent lookups of one key while letting lookups
+// of different keys run in parallel. The zero value is ready to use.
+type lookupGroup struct {
+	mu       sync.Mutex
+	inflight map[string]*lookupCall
+}
+
+// do runs lookup for key, unless a lookup of key is already in progress, in
+// which case it waits for that one and returns its result.
+func (g *lookupGroup) do(ctx context.Context, key string, lookup ContextLookup) (interface{}, error) {
+	for {
+		g.mu.Lock()
+		if g.inflight == nil {
+			g.inflight = map[string]*lookupCall{}
+		}
+		call, waiting := g.inflight[key]
+		if !waiting {
+			call = &lookupCall{done: make(chan struct{})}
+			g.inflight[key] = call
+		}
+		g.mu.Unlock()
+
+		if !waiting {
+			g.run(ctx, key, call, lookup)
+			return call.value, call.err
+		}
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if call.cancelled && ctx.Err() == nil {
+			continue
+		}
+		return call.value, call.err
+	}
+}
+
+func (g *lookupGroup) run(ctx context.Context, key string, call *lookupCall, lookup ContextLookup) {
+	call.err = errLookupPanicked
+	defer func() {
+		g.mu.Lock()
+		delete(g.inflight, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.value, call.err = lookup(ctx, key)
+	call.cancelled = call.err != nil && ctx.Err() != nil
+}
+
+// defaultCache is an LRU cache whose entries expire after timeout. Concurrent
+// lookups of one key are coalesced, and lookups of different keys run in
+// parallel.
 type defaultCache struct {
-	cache  *cache.Cache
-	lookup func(string) (interface{}, error)
-	mutex  *sync.Mutex
+	lookup     ContextLookup
+	timeout    time.Duration
+	cleanup    time.Duration
+	maxEntries int
+	calls      lookupGroup
+
+	mu        sync.Mutex
+	entries   map[string]*list.Element
+	order     *list.List // of *cacheEntry, most recently used first
+	lastPurge time.Time
 }
 
 func (c *defaultCache) Get(key string) (interface{}, error) {
-	if value, found := c.cache.Get(key); found {
-		return value, nil
-	}
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-	// once lock, check the cache again because there could be
-	// another thread that has update the keys during the last check
-	if value, found := c.cache.Get(key); found {
+	return c.GetContext(context.Background(), key)
+}
+
+func (c *defaultCache) GetContext(ctx context.Context, key string) (interface{}, error) {
+	if value, found := c.cached(key); found {
 		return value, nil
 	}
+	return c.calls.do(ctx, key, func(ctx context.Context, key string) (interface{}, error) {
+		// a lookup of key may have finished since the check above
+		if value, found := c.cached(key); found {
+			return value, nil
+		}
+		return c.fetch(ctx, key)
+	})
+}
+
+// Refresh looks key up again, unless a lookup of key is already in progress,
+// in which case its result is returned.
+func (c *defaultCache) Refresh(ctx context.Context, key string) (interface{}, error) {
+	return c.calls.do(ctx, key, c.fetch)
+}
+
+func (c *defaultCache) cached(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.get(key)
+}
 
-	value, err := c.lookup(key)
+func (c *defaultCache) fetch(ctx context.Context, key string) (interface{}, error) {
+	value, err := c.lookup(ctx, key)
 	if err != nil {
 		return nil, err
 	}
-
-	c.cache.SetDefault(key, value)
+	c.mu.Lock()
+	c.set(key, value)
+	c.mu.Unlock()
 	return value, nil
 }
 
-// defaultCache implements the Cacher interface
-var _ Cacher = (*defaultCache)(nil)
+// get returns the value of key if it has not expired. c.mu must be held.
+func (c *defaultCache) get(key string) (interface{}, bool) {
+	element, found := c.entries[key]
+	if !found {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if c.expired(entry, time.Now()) {
+		c.remove(element)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return entry.value, true
+}
+
+// set stores value under key, evicting the least recently used entries
+// beyond maxEntries. Expired entries are purged every cleanup. c.mu must be
+// held.
+func (c *defaultCache) set(key string, value interface{}) {
+	now := time.Now()
+	var expires time.Time
+	if c.timeout > 0 {
+		expires = now.Add(c.timeout)
+	}
+
+	if element, found := c.entries[key]; found {
+		entry := element.Value.(*cacheEntry)
+		entry.value, entry.expires = value, expires
+		c.order.MoveToFront(element)
+	} else {
+		c.entries[key] = c.order.PushFront(&cacheEntry{key: key, value: value, expires: expires})
+	}
+
+	if c.cleanup > 0 && now.Sub(c.lastPurge) >= c.cleanup {
+		c.lastPurge = now
+		for element := c.order.Front(); element != nil; {
+			next := element.Next()
+			if c.expired(element.Value.(*cacheEntry), now) {
+				c.remove(element)
+			}
+			element = next
+		}
+	}
+	for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *defaultCache) expired(entry *cacheEntry, now time.Time) bool {
+	return !entry.expires.IsZero() && now.After(entry.expires)
+}
+
+func (c *defaultCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).key)
+}
+
+// defaultCache implements the ContextCacher and Refresher interfaces
+var (
+	_ ContextCacher = (*defaultCache)(nil)
+	_ Refresher     = (*defaultCache)(nil)
+)
 
 func NewDefaultCache(lookup func(string) (interface{}, error), timeout, cleanup time.Duration) (Cacher, error) {
+	return NewDefaultContextCache(func(_ context.Context, key string) (interface{}, error) {
+		return lookup(key)
+	}, timeout, cleanup)
+}
+
+// NewDefaultContextCache is like NewDefaultCache but hands the caller's
+// context to lookup.
+func NewDefaultContextCache(lookup ContextLookup, timeout, cleanup time.Duration) (ContextCacher, error) {
+	return NewBoundedContextCache(lookup, timeout, cleanup, DefaultCacheMaxEntries)
+}
+
+// NewBoundedContextCache is like NewDefaultContextCache but keeps at most
+// maxEntries entries, evicting the least recently used ones. A maxEntries of
+// zero or less leaves the cache unbounded.
+func NewBoundedContextCache(lookup ContextLookup, timeout, cleanup time.Duration, maxEntries int) (ContextCacher, error) {
 	return &defaultCache{
-		cache:  cache.New(timeout, cleanup),
-		lookup: lookup,
-		mutex:  &sync.Mutex{},
+		lookup:     lookup,
+		timeout:    timeout,
+		cleanup:    cleanup,
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		order:      list.New(),
+		lastPurge:  time.Now(),
 	}, nil
 }
@@ -1,10 +1,16 @@
 package utils_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/hung12ct/okta-jwt-verifier-golang/v2/utils"
+	gocache "github.com/patrickmn/go-cache"
 )
 
 type Value struct {
@@ -48,3 +54,295 @@ func TestNewDefaultCache(t *testing.T) {
 		t.Error("Expected cached value to be the same")
 	}
 }
+
+func TestDefaultContextCacheWaiterHonoursContext(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	lookup := func(ctx context.Context, key string) (interface{}, error) {
+		close(started)
+		<-release
+		return &Value{key: key}, nil
+	}
+	cache, err := utils.NewDefaultContextCache(lookup, 5*time.Minute, 10*time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := cache.GetContext(context.Background(), "slow"); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := cache.GetContext(ctx, "slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+
+	close(release)
+	<-done
+}
+
+func TestDefaultContextCacheCoalescesLookupsOfOneKey(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	lookup := func(ctx context.Context, key string) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &Value{key: key}, nil
+	}
+	cache, err := utils.NewDefaultContextCache(lookup, 5*time.Minute, 10*time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	values := make([]interface{}, 10)
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], _ = cache.Get("key")
+		}(i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("Expected one lookup, got %d", calls)
+	}
+	for _, value := range values {
+		if value != values[0] {
+			t.Fatalf("Expected every caller to get the same value")
+		}
+	}
+}
+
+func TestDefaultContextCacheDoesNotBlockOtherKeys(t *testing.T) {
+	release := make(chan struct{})
+	lookup := func(ctx context.Context, key string) (interface{}, error) {
+		if key == "slow" {
+			<-release
+		}
+		return &Value{key: key}, nil
+	}
+	cache, err := utils.NewDefaultContextCache(lookup, 5*time.Minute, 10*time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer close(release)
+
+	go cache.Get("slow")
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := cache.GetContext(ctx, "fast"); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestDefaultContextCacheRetriesLookupsCancelledByAnotherCaller(t *testing.T) {
+	started := make(chan struct{}, 2)
+	lookup := func(ctx context.Context, key string) (interface{}, error) {
+		started <- struct{}{}
+		if len(started) == 1 {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return &Value{key: key}, nil
+	}
+	cache, err := utils.NewDefaultContextCache(lookup, 5*time.Minute, 10*time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go cache.GetContext(ctx, "key")
+	for len(started) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	result := make(chan error)
+	go func() {
+		_, err := cache.GetContext(context.Background(), "key")
+		result <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	if err := <-result; err != nil {
+		t.Errorf("Expected the lookup to be retried, got %v", err)
+	}
+}
+
+func TestBoundedContextCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	calls := map[string]int{}
+	lookup := func(ctx context.Context, key string) (interface{}, error) {
+		calls[key]++
+		return &Value{key: key}, nil
+	}
+	cache, err := utils.NewBoundedContextCache(lookup, 5*time.Minute, 10*time.Minute, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, key := range []string{"a", "b", "a", "c", "a", "b"} {
+		if _, err := cache.Get(key); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	// c evicted b, which was looked up again and evicted c
+	expected := map[string]int{"a": 1, "b": 2, "c": 1}
+	for key, count := range expected {
+		if calls[key] != count {
+			t.Errorf("Expected %d lookups of %s, got %d", count, key, calls[key])
+		}
+	}
+}
+
+func TestDefaultContextCacheEntriesExpire(t *testing.T) {
+	calls := 0
+	lookup := func(ctx context.Context, key string) (interface{}, error) {
+		calls++
+		return &Value{key: key}, nil
+	}
+	cache, err := utils.NewDefaultContextCache(lookup, 50*time.Millisecond, 10*time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cache.Get("key")
+	cache.Get("key")
+	time.Sleep(100 * time.Millisecond)
+	cache.Get("key")
+	if calls != 2 {
+		t.Errorf("Expected 2 lookups, got %d", calls)
+	}
+}
+
+// mutexCache is the default cache as it was before lookups were coalesced
+// per key: a go-cache guarded by a single lock for every key. It is kept to
+// benchmark the current implementation against.
+type mutexCache struct {
+	cache  *gocache.Cache
+	lookup utils.ContextLookup
+	lock   chan struct{}
+}
+
+func newMutexCache(lookup utils.ContextLookup, timeout, cleanup time.Duration) (utils.ContextCacher, error) {
+	return &mutexCache{cache: gocache.New(timeout, cleanup), lookup: lookup, lock: make(chan struct{}, 1)}, nil
+}
+
+func (c *mutexCache) Get(key string) (interface{}, error) {
+	return c.GetContext(context.Background(), key)
+}
+
+func (c *mutexCache) GetContext(ctx context.Context, key string) (interface{}, error) {
+	if value, found := c.cache.Get(key); found {
+		return value, nil
+	}
+	select {
+	case c.lock <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-c.lock }()
+	if value, found := c.cache.Get(key); found {
+		return value, nil
+	}
+	value, err := c.lookup(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	c.cache.SetDefault(key, value)
+	return value, nil
+}
+
+var cacheImplementations = []struct {
+	name string
+	new  func(utils.ContextLookup, time.Duration, time.Duration) (utils.ContextCacher, error)
+}{
+	{"mutex", newMutexCache},
+	{"default", utils.NewDefaultContextCache},
+}
+
+// BenchmarkCacheHits reads cached keys from many goroutines.
+func BenchmarkCacheHits(b *testing.B) {
+	for _, impl := range cacheImplementations {
+		b.Run(impl.name, func(b *testing.B) {
+			cache, _ := impl.new(func(ctx context.Context, key string) (interface{}, error) {
+				return &Value{key: key}, nil
+			}, 5*time.Minute, 10*time.Minute)
+			keys := make([]string, 100)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("https://issuer-%d.example.com/v1/keys", i)
+				cache.Get(keys[i])
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					cache.Get(keys[i%len(keys)])
+					i++
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkCacheSlowMisses looks up distinct keys whose lookup takes a
+// millisecond, as fetching the keys of many issuers at once does.
+func BenchmarkCacheSlowMisses(b *testing.B) {
+	for _, impl := range cacheImplementations {
+		b.Run(impl.name, func(b *testing.B) {
+			cache, _ := impl.new(func(ctx context.Context, key string) (interface{}, error) {
+				time.Sleep(time.Millisecond)
+				return &Value{key: key}, nil
+			}, 5*time.Minute, 10*time.Minute)
+			var n int64
+
+			b.ResetTimer()
+			b.SetParallelism(16)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					cache.Get(fmt.Sprintf("key-%d", atomic.AddInt64(&n, 1)))
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkCacheConcurrentMisses has many goroutines miss on the same key at
+// once, as happens when a popular entry expires.
+func BenchmarkCacheConcurrentMisses(b *testing.B) {
+	for _, impl := range cacheImplementations {
+		b.Run(impl.name, func(b *testing.B) {
+			var lookups int64
+			for i := 0; i < b.N; i++ {
+				cache, _ := impl.new(func(ctx context.Context, key string) (interface{}, error) {
+					atomic.AddInt64(&lookups, 1)
+					time.Sleep(100 * time.Microsecond)
+					return &Value{key: key}, nil
+				}, 5*time.Minute, 10*time.Minute)
+
+				var wg sync.WaitGroup
+				for g := 0; g < 32; g++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						cache.Get("key")
+					}()
+				}
+				wg.Wait()
+			}
+			b.ReportMetric(float64(lookups)/float64(b.N), "lookups/op")
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+type refreshingEntry struct {
+	value      interface{}
+	expires    time.Time
+	lastAccess time.Time
+}
+
+type refreshingCache struct {
+	lookup  ContextLookup
+	timeout time.Duration
+	cleanup time.Duration
+	grace   time.Duration
+
+	// calls coalesces foreground lookups of each key
+	calls lookupGroup
+
+	mutex   sync.Mutex
+	entries map[string]*refreshingEntry
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewRefreshingCache returns a ContextCacher that renews every entry in the
+// background before it expires after timeout. When renewing fails the last
+// good value keeps being served for up to grace past its expiry. Entries
+// that have not been read for cleanup stop being renewed and are dropped.
+//
+// The returned cache implements io.Closer; Close stops the background
+// goroutines. timeout must be positive.
+func NewRefreshingCache(lookup ContextLookup, timeout, cleanup, grace time.Duration) (ContextCacher, error) {
+	if timeout <= 0 {
+		return nil, fmt.Errorf("the timeout of a refreshing cache must be positive, got %s", timeout)
+	}
+	if grace < 0 {
+		grace = 0
+	}
+	return &refreshingCache{
+		lookup:  lookup,
+		timeout: timeout,
+		cleanup: cleanup,
+		grace:   grace,
+		entries: map[string]*refreshingEntry{},
+		done:    make(chan struct{}),
+	}, nil
+}
+
+func (c *refreshingCache) Get(key string) (interface{}, error) {
+	return c.GetContext(context.Background(), key)
+}
+
+func (c *refreshingCache) GetContext(ctx context.Context, key string) (interface{}, error) {
+	if value, ok := c.usable(key); ok {
+		return value, nil
+	}
+	return c.calls.do(ctx, key, func(ctx context.Context, key string) (interface{}, error) {
+		if value, ok := c.usable(key); ok {
+			return value, nil
+		}
+		return c.fetch(ctx, key)
+	})
+}
+
+func (c *refreshingCache) Refresh(ctx context.Context, key string) (interface{}, error) {
+	return c.calls.do(ctx, key, c.fetch)
+}
+
+// Close stops renewing entries in the background.
+func (c *refreshingCache) Close() error {
+	c.closeOnce.Do(func() {
+		// fetch starts renewals under the same lock, so none can be added
+		// to c.wg once done is closed
+		c.mutex.Lock()
+		close(c.done)
+		c.mutex.Unlock()
+	})
+	c.wg.Wait()
+	return nil
+}
+
+// usable returns the value for key if it is fresh or still within the grace
+// period.
+func (c *refreshingCache) usable(key string) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	now := time.Now()
+	if now.After(entry.expires.Add(c.grace)) {
+		return nil, false
+	}
+	entry.lastAccess = now
+	return entry.value, true
+}
+
+// fetch looks key up in the foreground and starts renewing it in the
+// background if it was not already.
+func (c *refreshingCache) fetch(ctx context.Context, key string) (interface{}, error) {
+	value, err := c.lookup(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	now := time.Now()
+	entry, renewing := c.entries[key]
+	if !renewing {
+		entry = &refreshingEntry{}
+		c.entries[key] = entry
+	}
+	entry.value = value
+	entry.expires = now.Add(c.timeout)
+	entry.lastAccess = now
+	if !renewing {
+		select {
+		case <-c.done:
+		default:
+			c.wg.Add(1)
+			go c.renew(key)
+		}
+	}
+	c.mutex.Unlock()
+	return value, nil
+}
+
+// renew refreshes key when three quarters of its lifetime have passed, and
+// retries more often after a failure until the entry falls out of its grace
+// period. The entry is then dropped, and the next caller looks it up in the
+// foreground, which starts renewing it again.
+func (c *refreshingCache) renew(key string) {
+	defer c.wg.Done()
+
+	retry := c.timeout / 8
+	if retry <= 0 {
+		retry = time.Second
+	}
+
+	c.mutex.Lock()
+	next := c.entries[key].expires.Add(-c.timeout / 4)
+	c.mutex.Unlock()
+
+	for {
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-c.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		c.mutex.Lock()
+		entry := c.entries[key]
+		idle := c.cleanup > 0 && time.Since(entry.lastAccess) > c.cleanup
+		if idle {
+			delete(c.entries, key)
+		}
+		c.mutex.Unlock()
+		if idle {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), retry)
+		value, err := c.lookup(ctx, key)
+		cancel()
+		if err != nil {
+			c.mutex.Lock()
+			now := time.Now()
+			stale := now.After(entry.expires.Add(c.grace))
+			if stale {
+				delete(c.entries, key)
+			}
+			c.mutex.Unlock()
+			if stale {
+				return
+			}
+			next = now.Add(retry)
+			continue
+		}
+
+		c.mutex.Lock()
+		now := time.Now()
+		entry.value = value
+		entry.expires = now.Add(c.timeout)
+		c.mutex.Unlock()
+		next = now.Add(c.timeout - c.timeout/4)
+	}
+}
+
+// refreshingCache implements the ContextCacher, Refresher and io.Closer
+// interfaces
+var (
+	_ ContextCacher = (*refreshingCache)(nil)
+	_ Refresher     = (*refreshingCache)(nil)
+	_ io.Closer     = (*refreshingCache)(nil)
+)
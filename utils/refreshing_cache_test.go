@@ -0,0 +1,214 @@
+package utils_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/utils"
+)
+
+func TestRefreshingCacheRenewsInBackground(t *testing.T) {
+	var calls int64
+	lookup := func(ctx context.Context, key string) (interface{}, error) {
+		return atomic.AddInt64(&calls, 1), nil
+	}
+	cache, err := utils.NewRefreshingCache(lookup, 100*time.Millisecond, time.Minute, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer cache.(io.Closer).Close()
+
+	first, err := cache.Get("key")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if first != int64(1) {
+		t.Fatalf("Expected first lookup, got %v", first)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	if atomic.LoadInt64(&calls) < 2 {
+		t.Fatalf("Expected the entry to be renewed in the background")
+	}
+	second, err := cache.Get("key")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if second == first {
+		t.Errorf("Expected a renewed value")
+	}
+}
+
+func TestRefreshingCacheServesStaleValueDuringGracePeriod(t *testing.T) {
+	var failing int32
+	lookup := func(ctx context.Context, key string) (interface{}, error) {
+		if atomic.LoadInt32(&failing) == 1 {
+			return nil, errors.New("authorization server is down")
+		}
+		return "good", nil
+	}
+	cache, err := utils.NewRefreshingCache(lookup, 50*time.Millisecond, time.Minute, 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer cache.(io.Closer).Close()
+
+	if _, err := cache.Get("key"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	atomic.StoreInt32(&failing, 1)
+
+	// expired, but within the grace period
+	time.Sleep(100 * time.Millisecond)
+	value, err := cache.Get("key")
+	if err != nil {
+		t.Fatalf("Expected the stale value, got error %v", err)
+	}
+	if value != "good" {
+		t.Errorf("Expected the stale value, got %v", value)
+	}
+
+	// past the grace period
+	time.Sleep(200 * time.Millisecond)
+	if _, err := cache.Get("key"); err == nil {
+		t.Errorf("Expected an error once the grace period is over")
+	}
+}
+
+func TestRefreshingCacheCloseStopsRenewing(t *testing.T) {
+	var calls int64
+	lookup := func(ctx context.Context, key string) (interface{}, error) {
+		return atomic.AddInt64(&calls, 1), nil
+	}
+	cache, err := utils.NewRefreshingCache(lookup, 20*time.Millisecond, time.Minute, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := cache.Get("key"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := cache.(io.Closer).Close(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	closed := atomic.LoadInt64(&calls)
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt64(&calls) != closed {
+		t.Errorf("Expected no lookups after Close")
+	}
+}
+
+func TestRefreshingCacheCloseWhileLookingUp(t *testing.T) {
+	lookup := func(ctx context.Context, key string) (interface{}, error) {
+		return key, nil
+	}
+	cache, err := utils.NewRefreshingCache(lookup, time.Minute, time.Minute, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _ = cache.Get(fmt.Sprintf("key-%d", i))
+		}(i)
+	}
+	if err := cache.(io.Closer).Close(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	wg.Wait()
+}
+
+func TestRefreshingCacheRejectsNonPositiveTimeout(t *testing.T) {
+	lookup := func(ctx context.Context, key string) (interface{}, error) {
+		return key, nil
+	}
+	for _, timeout := range []time.Duration{0, -time.Minute} {
+		if _, err := utils.NewRefreshingCache(lookup, timeout, 0, 0); err == nil {
+			t.Errorf("Expected an error for timeout %s", timeout)
+		}
+	}
+}
+
+func TestRefreshingCacheStopsRetryingAfterGracePeriod(t *testing.T) {
+	var calls int64
+	lookup := func(ctx context.Context, key string) (interface{}, error) {
+		if atomic.AddInt64(&calls, 1) > 1 {
+			return nil, errors.New("authorization server is down")
+		}
+		return "value", nil
+	}
+	cache, err := utils.NewRefreshingCache(lookup, 40*time.Millisecond, time.Minute, 40*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer cache.(io.Closer).Close()
+
+	if _, err := cache.Get("key"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// the entry is out of its grace period 80ms after it was looked up
+	time.Sleep(150 * time.Millisecond)
+	stopped := atomic.LoadInt64(&calls)
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt64(&calls) != stopped {
+		t.Errorf("Expected no renewals once the grace period is over, got %d more", atomic.LoadInt64(&calls)-stopped)
+	}
+
+	// the next caller looks the entry up in the foreground
+	if _, err := cache.Get("key"); err == nil {
+		t.Errorf("Expected the foreground lookup error")
+	}
+	if atomic.LoadInt64(&calls) != stopped+1 {
+		t.Errorf("Expected a single foreground lookup")
+	}
+}
+
+func TestRefreshingCacheDoesNotBlockOtherKeys(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	lookup := func(ctx context.Context, key string) (interface{}, error) {
+		if key == "slow" {
+			atomic.AddInt32(&calls, 1)
+			<-release
+		}
+		return key, nil
+	}
+	cache, err := utils.NewRefreshingCache(lookup, time.Minute, time.Minute, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer cache.(io.Closer).Close()
+
+	done := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, _ = cache.Get("slow")
+			done <- struct{}{}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := cache.GetContext(ctx, "fast"); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	close(release)
+	<-done
+	<-done
+	if calls != 1 {
+		t.Errorf("Expected one lookup of the slow key, got %d", calls)
+	}
+}
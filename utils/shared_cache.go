@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// Codec converts the values held by a shared cache to and from bytes.
+type Codec interface {
+	Marshal(value interface{}) ([]byte, error)
+	Unmarshal(data []byte) (interface{}, error)
+}
+
+type sharedCache struct {
+	local   *cache.Cache
+	store   KeyValueStore
+	codec   Codec
+	prefix  string
+	lookup  ContextLookup
+	timeout time.Duration
+	// calls coalesces the lookups of each key made by this process
+	calls lookupGroup
+}
+
+// NewSharedContextCache returns a cache constructor, for the ContextCache
+// field of the verifier and adaptor, whose caches share the values they
+// look up through store. A fleet of processes using the same store thus
+// fetches each resource once per timeout instead of once per process.
+//
+// Values are stored under prefix followed by their key, encoded with codec,
+// and are also kept in memory until they expire. The store is only an
+// optimization: when it fails, values are looked up as if it were empty.
+func NewSharedContextCache(store KeyValueStore, codec Codec, prefix string) func(ContextLookup, time.Duration, time.Duration) (ContextCacher, error) {
+	return func(lookup ContextLookup, timeout, cleanup time.Duration) (ContextCacher, error) {
+		return &sharedCache{
+			local:   cache.New(timeout, cleanup),
+			store:   store,
+			codec:   codec,
+			prefix:  prefix,
+			lookup:  lookup,
+			timeout: timeout,
+		}, nil
+	}
+}
+
+func (c *sharedCache) Get(key string) (interface{}, error) {
+	return c.GetContext(context.Background(), key)
+}
+
+func (c *sharedCache) GetContext(ctx context.Context, key string) (interface{}, error) {
+	if value, found := c.local.Get(key); found {
+		return value, nil
+	}
+	return c.calls.do(ctx, key, func(ctx context.Context, key string) (interface{}, error) {
+		if value, found := c.local.Get(key); found {
+			return value, nil
+		}
+		if value, ttl, found := c.load(ctx, key); found {
+			c.local.Set(key, value, ttl)
+			return value, nil
+		}
+		return c.fetch(ctx, key)
+	})
+}
+
+// Refresh looks key up again and shares the new value through the store,
+// unless a lookup of key is already in progress, in which case its result
+// is returned.
+func (c *sharedCache) Refresh(ctx context.Context, key string) (interface{}, error) {
+	return c.calls.do(ctx, key, c.fetch)
+}
+
+// load returns the value of key from the store along with the time it has
+// left before it expires. Entries are the expiry time, as big endian Unix
+// nanoseconds, followed by the encoded value.
+func (c *sharedCache) load(ctx context.Context, key string) (interface{}, time.Duration, bool) {
+	data, found, err := c.store.Get(ctx, c.prefix+key)
+	if err != nil || !found || len(data) < 8 {
+		return nil, 0, false
+	}
+	ttl := time.Until(time.Unix(0, int64(binary.BigEndian.Uint64(data))))
+	if ttl <= 0 {
+		return nil, 0, false
+	}
+	value, err := c.codec.Unmarshal(data[8:])
+	if err != nil {
+		return nil, 0, false
+	}
+	return value, ttl, true
+}
+
+func (c *sharedCache) fetch(ctx context.Context, key string) (interface{}, error) {
+	value, err := c.lookup(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	c.local.Set(key, value, c.timeout)
+
+	if encoded, err := c.codec.Marshal(value); err == nil {
+		data := make([]byte, 8, 8+len(encoded))
+		binary.BigEndian.PutUint64(data, uint64(time.Now().Add(c.timeout).UnixNano()))
+		_ = c.store.Set(ctx, c.prefix+key, append(data, encoded...), c.timeout)
+	}
+	return value, nil
+}
+
+// sharedCache implements the ContextCacher and Refresher interfaces
+var (
+	_ ContextCacher = (*sharedCache)(nil)
+	_ Refresher     = (*sharedCache)(nil)
+)
@@ -0,0 +1,176 @@
+package utils_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/utils"
+)
+
+// fakeStore is an in-process stand-in for a store such as Redis that can be
+// made to fail.
+type fakeStore struct {
+	utils.KeyValueStore
+
+	mu   sync.Mutex
+	fail bool
+	keys []string
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{KeyValueStore: utils.NewMemoryStore(time.Minute)}
+}
+
+func (s *fakeStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		return nil, false, errors.New("store is down")
+	}
+	return s.KeyValueStore.Get(ctx, key)
+}
+
+func (s *fakeStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		return errors.New("store is down")
+	}
+	s.keys = append(s.keys, key)
+	return s.KeyValueStore.Set(ctx, key, value, ttl)
+}
+
+type stringCodec struct{}
+
+func (stringCodec) Marshal(value interface{}) ([]byte, error) {
+	return []byte(value.(string)), nil
+}
+
+func (stringCodec) Unmarshal(data []byte) (interface{}, error) {
+	return string(data), nil
+}
+
+func countingLookup(calls *int) utils.ContextLookup {
+	return func(ctx context.Context, key string) (interface{}, error) {
+		*calls++
+		return fmt.Sprintf("%s #%d", key, *calls), nil
+	}
+}
+
+func TestSharedCacheSharesValuesThroughTheStore(t *testing.T) {
+	store := newFakeStore()
+	newCache := utils.NewSharedContextCache(store, stringCodec{}, "prefix:")
+
+	calls := 0
+	first, _ := newCache(countingLookup(&calls), time.Minute, time.Minute)
+	second, _ := newCache(countingLookup(&calls), time.Minute, time.Minute)
+
+	value, err := first.Get("key")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if value != "key #1" {
+		t.Errorf("Expected key #1, got %v", value)
+	}
+	if len(store.keys) != 1 || store.keys[0] != "prefix:key" {
+		t.Errorf("Expected the value to be stored under prefix:key, got %v", store.keys)
+	}
+
+	value, err = second.Get("key")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if value != "key #1" || calls != 1 {
+		t.Errorf("Expected the shared value without a lookup, got %v after %d lookups", value, calls)
+	}
+
+	// a refresh is shared with caches that do not hold the key yet
+	if _, err := first.(utils.Refresher).Refresh(context.Background(), "key"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	third, _ := newCache(countingLookup(&calls), time.Minute, time.Minute)
+	if value, _ := third.Get("key"); value != "key #2" {
+		t.Errorf("Expected the refreshed value, got %v", value)
+	}
+}
+
+func TestSharedCacheEntriesExpire(t *testing.T) {
+	store := newFakeStore()
+	newCache := utils.NewSharedContextCache(store, stringCodec{}, "")
+
+	calls := 0
+	first, _ := newCache(countingLookup(&calls), 50*time.Millisecond, time.Minute)
+	if _, err := first.Get("key"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	second, _ := newCache(countingLookup(&calls), 50*time.Millisecond, time.Minute)
+	if value, _ := second.Get("key"); value != "key #2" {
+		t.Errorf("Expected the expired value to be looked up again, got %v", value)
+	}
+}
+
+func TestSharedCacheWorksWithoutTheStore(t *testing.T) {
+	store := newFakeStore()
+	store.fail = true
+	newCache := utils.NewSharedContextCache(store, stringCodec{}, "")
+
+	calls := 0
+	cache, _ := newCache(countingLookup(&calls), time.Minute, time.Minute)
+	for i := 0; i < 2; i++ {
+		value, err := cache.Get("key")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if value != "key #1" {
+			t.Errorf("Expected key #1, got %v", value)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected values to be kept in memory, got %d lookups", calls)
+	}
+}
+
+func TestSharedCacheDoesNotBlockOtherKeys(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	lookup := func(ctx context.Context, key string) (interface{}, error) {
+		if key == "slow" {
+			atomic.AddInt32(&calls, 1)
+			<-release
+		}
+		return key, nil
+	}
+	cache, err := utils.NewSharedContextCache(newFakeStore(), stringCodec{}, "test:")(lookup, time.Minute, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	done := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, _ = cache.Get("slow")
+			done <- struct{}{}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := cache.GetContext(ctx, "fast"); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	close(release)
+	<-done
+	<-done
+	if calls != 1 {
+		t.Errorf("Expected one lookup of the slow key, got %d", calls)
+	}
+}
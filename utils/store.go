@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"context"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// KeyValueStore is a byte-level key/value store whose entries expire, such
+// as Redis or memcached.
+//
+// Get reports whether key is present and returns its value. Set stores value
+// under key for ttl.
+type KeyValueStore interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+type memoryStore struct {
+	cache *cache.Cache
+}
+
+// NewMemoryStore returns a KeyValueStore that keeps its entries in memory.
+// Expired entries are removed every cleanup.
+func NewMemoryStore(cleanup time.Duration) KeyValueStore {
+	return &memoryStore{cache: cache.New(cache.NoExpiration, cleanup)}
+}
+
+func (s *memoryStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, found := s.cache.Get(key)
+	if !found {
+		return nil, false, nil
+	}
+	return append([]byte(nil), value.([]byte)...), true, nil
+}
+
+func (s *memoryStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		s.cache.Delete(key)
+		return nil
+	}
+	s.cache.Set(key, append([]byte(nil), value...), ttl)
+	return nil
+}
@@ -0,0 +1,30 @@
+package utils_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hung12ct/okta-jwt-verifier-golang/v2/utils"
+)
+
+func TestMemoryStoreExpiresEntries(t *testing.T) {
+	ctx := context.Background()
+	store := utils.NewMemoryStore(time.Minute)
+
+	if err := store.Set(ctx, "key", []byte("value"), 50*time.Millisecond); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	value, found, err := store.Get(ctx, "key")
+	if err != nil || !found {
+		t.Fatalf("Expected the value to be found, got %v, %v", found, err)
+	}
+	if string(value) != "value" {
+		t.Errorf("Expected value, got %q", value)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if _, found, _ := store.Get(ctx, "key"); found {
+		t.Errorf("Expected the value to have expired")
+	}
+}